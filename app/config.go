@@ -1,5 +1,12 @@
 package app
 
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
 // AppConfig is a dandelion app config structure.
 type AppConfig struct {
 	ID          int64  `db:"id" json:"id"`
@@ -15,24 +22,84 @@ type AppConfig struct {
 	UpdatedTime int64  `db:"updated_time" json:"updated_time"`
 }
 
+// CommitAuthor is app config commit author structure
+type CommitAuthor struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	When  time.Time `json:"when"`
+}
+
+// Commit is app config commit structure
+type Commit struct {
+	Branch   string       `json:"branch"`
+	CommitID string       `json:"commit_id"`
+	Message  string       `json:"message"`
+	Author   CommitAuthor `json:"author"`
+}
+
 // Status is a dandelion app instance status structure
 type Status struct {
-	ID          int64  `json:"-" db:"id"`
-	AppID       string `json:"app_id" db:"app_id"`
-	Host        string `json:"host" db:"host"`
-	InstanceID  string `json:"instance_id" db:"instance_id"`
-	ConfigID    int64  `json:"config_id,omitempty" db:"config_id"`
-	CommitID    string `json:"commit_id,omitempty" db:"commit_id"`
-	Status      int    `json:"status" db:"status"`
-	CreatedTime int64  `json:"created_time,omitempty" db:"created_time"`
-	UpdatedTime int64  `json:"updated_time,omitempty" db:"updated_time"`
+	ID          int64    `json:"-" db:"id"`
+	AppID       string   `json:"app_id" db:"app_id"`
+	Host        string   `json:"host" db:"host"`
+	InstanceID  string   `json:"instance_id" db:"instance_id"`
+	ConfigID    int64    `json:"config_id,omitempty" db:"config_id"`
+	CommitID    string   `json:"commit_id,omitempty" db:"commit_id"`
+	Status      int      `json:"status" db:"status"`
+	DriftFiles  FileList `json:"drift_files,omitempty" db:"drift_files"`
+	CreatedTime int64    `json:"created_time,omitempty" db:"created_time"`
+	UpdatedTime int64    `json:"updated_time,omitempty" db:"updated_time"`
+}
+
+// MaxDriftFilesLength is the max length of stored drift files
+const MaxDriftFilesLength = 4096
+
+// FileList is a list of files, stored as newline separated text
+type FileList []string
+
+// Truncate returns the leading files of which the stored text is not longer
+// than max bytes
+func (l FileList) Truncate(max int) FileList {
+	n := -1
+	for i, file := range l {
+		n += len(file) + 1
+		if n > max {
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// Value implements the driver.Valuer interface
+func (l FileList) Value() (driver.Value, error) {
+	return strings.Join(l, "\n"), nil
+}
+
+// Scan implements the sql.Scanner interface
+func (l *FileList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unsupported type %T for file list", src)
+	}
+	if s == "" {
+		*l = nil
+		return nil
+	}
+	*l = strings.Split(s, "\n")
+	return nil
 }
 
 // ClientConfig is client app config
 type ClientConfig struct {
-	ID         int
-	AppID      string
-	Host       string
-	InstanceID string
-	Version    string
+	ID         int    `json:"id"`
+	AppID      string `json:"app_id"`
+	Host       string `json:"host"`
+	InstanceID string `json:"instance_id"`
+	Version    string `json:"version"`
 }
@@ -0,0 +1,28 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileListTruncate(t *testing.T) {
+	assert := assert.New(t)
+
+	l := FileList{"a.conf", "b/c.conf", "d.conf"}
+	assert.Equal(l, l.Truncate(100))
+	// "a.conf\nb/c.conf\nd.conf" is 22 bytes
+	assert.Equal(l, l.Truncate(22))
+	assert.Equal(FileList{"a.conf", "b/c.conf"}, l.Truncate(21))
+	assert.Equal(FileList{"a.conf"}, l.Truncate(6))
+	assert.Empty(l.Truncate(5))
+	assert.Empty(FileList(nil).Truncate(10))
+
+	var long FileList
+	for i := 0; i < 1000; i++ {
+		long = append(long, "conf/drifted.conf")
+	}
+	v, err := long.Truncate(MaxDriftFilesLength).Value()
+	assert.NoError(err)
+	assert.LessOrEqual(len(v.(string)), MaxDriftFilesLength)
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// file actions of diff
+const (
+	FileActionAdd    = "add"
+	FileActionDelete = "delete"
+	FileActionModify = "modify"
+)
+
+// FileDiff is the difference of a file
+type FileDiff struct {
+	File   string `json:"file"`
+	Action string `json:"action"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// SplitLines splits data into lines with line endings, unlike
+// difflib.SplitLines no empty line is appended after the last line ending
+func SplitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines splits data into lines for diff, the last line without line
+// ending is marked like diff(1) so that each emitted line ends with newline
+func diffLines(data []byte) []string {
+	lines := SplitLines(data)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n\\ No newline at end of file\n"
+	}
+	return lines
+}
+
+// UnifiedDiff generates the unified diff of file, the added file is diffed
+// from /dev/null and the deleted file is diffed to /dev/null
+func UnifiedDiff(name string, from, to []byte, action string) (string, error) {
+	fromFile, toFile := "a/"+name, "b/"+name
+	switch action {
+	case FileActionAdd:
+		fromFile = "/dev/null"
+	case FileActionDelete:
+		toFile = "/dev/null"
+	}
+	if bytes.IndexByte(from, 0) >= 0 || bytes.IndexByte(to, 0) >= 0 {
+		return fmt.Sprintf("Binary files %s and %s differ\n", fromFile, toFile), nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(from),
+		B:        diffLines(to),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+}
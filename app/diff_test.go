@@ -0,0 +1,46 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(SplitLines(nil))
+	assert.Equal([]string{"a\n", "b"}, SplitLines([]byte("a\nb")))
+	assert.Equal([]string{"a\n", "b\n"}, SplitLines([]byte("a\nb\n")))
+
+	d, err := UnifiedDiff("a.conf", []byte("x=1\ny=2\n"), []byte("x=1\ny=3\n"), FileActionModify)
+	assert.NoError(err)
+	assert.Equal("--- a/a.conf\n+++ b/a.conf\n@@ -1,2 +1,2 @@\n x=1\n-y=2\n+y=3\n", d)
+
+	d, err = UnifiedDiff("a.conf", nil, []byte("x=1\n"), FileActionAdd)
+	assert.NoError(err)
+	assert.Equal("--- /dev/null\n+++ b/a.conf\n@@ -0,0 +1 @@\n+x=1\n", d)
+
+	d, err = UnifiedDiff("a.conf", []byte("x=1\n"), nil, FileActionDelete)
+	assert.NoError(err)
+	assert.Equal("--- a/a.conf\n+++ /dev/null\n@@ -1 +0,0 @@\n-x=1\n", d)
+
+	// missing newline at end of file
+	d, err = UnifiedDiff("x.conf", []byte("a\nb"), []byte("a\nc\n"), FileActionModify)
+	assert.NoError(err)
+	assert.Equal("--- a/x.conf\n+++ b/x.conf\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n", d)
+	d, err = UnifiedDiff("x.conf", []byte("a\nb\n"), []byte("a\nc"), FileActionModify)
+	assert.NoError(err)
+	assert.Equal("--- a/x.conf\n+++ b/x.conf\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n\\ No newline at end of file\n", d)
+	d, err = UnifiedDiff("x.conf", []byte("a\nb"), []byte("a\nc"), FileActionModify)
+	assert.NoError(err)
+	assert.Equal("--- a/x.conf\n+++ b/x.conf\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n", d)
+	// only the line ending differs
+	d, err = UnifiedDiff("x.conf", []byte("a\nb"), []byte("a\nb\n"), FileActionModify)
+	assert.NoError(err)
+	assert.Equal("--- a/x.conf\n+++ b/x.conf\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n", d)
+
+	d, err = UnifiedDiff("bin", []byte{0, 1}, nil, FileActionDelete)
+	assert.NoError(err)
+	assert.Equal("Binary files a/bin and /dev/null differ\n", d)
+}
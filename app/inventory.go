@@ -0,0 +1,134 @@
+package app
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Inventory is a dandelion seed host inventory structure
+type Inventory struct {
+	ID          int64   `json:"-" db:"id"`
+	Host        string  `json:"host" db:"host"`
+	Version     string  `json:"version" db:"version"`
+	OS          string  `json:"os" db:"os"`
+	Arch        string  `json:"arch" db:"arch"`
+	Uptime      int64   `json:"uptime" db:"uptime"`
+	Labels      Labels  `json:"labels" db:"labels"`
+	Apps        AppList `json:"apps" db:"apps"`
+	CreatedTime int64   `json:"created_time,omitempty" db:"created_time"`
+	UpdatedTime int64   `json:"updated_time,omitempty" db:"updated_time"`
+}
+
+// Labels is a set of key value labels, stored as json text
+type Labels map[string]string
+
+// Value implements the driver.Valuer interface
+func (l Labels) Value() (driver.Value, error) {
+	if len(l) <= 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements the sql.Scanner interface
+func (l *Labels) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported type %T for labels", src)
+	}
+	if len(data) <= 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(data, l)
+}
+
+// AppList is a list of app ids, stored as newline separated text
+type AppList []string
+
+// Value implements the driver.Valuer interface
+func (l AppList) Value() (driver.Value, error) {
+	return FileList(l).Value()
+}
+
+// Scan implements the sql.Scanner interface
+func (l *AppList) Scan(src interface{}) error {
+	return (*FileList)(l).Scan(src)
+}
+
+type selectorRequirement struct {
+	key   string
+	value string
+	op    string
+}
+
+// Selector selects labels by requirements, like `zone=sh,role!=db,gpu,!canary`
+type Selector []selectorRequirement
+
+// ParseSelector parses comma separated label requirements, supported
+// requirements are `key=value`, `key!=value`, `key` (exists) and `!key`
+// (not exists)
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var r selectorRequirement
+		if i := strings.Index(part, "!="); i >= 0 {
+			r = selectorRequirement{key: part[:i], value: part[i+2:], op: "!="}
+		} else if i := strings.IndexByte(part, '='); i >= 0 {
+			r = selectorRequirement{key: part[:i], value: strings.TrimPrefix(part[i+1:], "="), op: "="}
+		} else if strings.HasPrefix(part, "!") {
+			r = selectorRequirement{key: part[1:], op: "!"}
+		} else {
+			r = selectorRequirement{key: part, op: ""}
+		}
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if r.key == "" {
+			return nil, fmt.Errorf("invalid label selector: %s", s)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Match checks whether labels match all requirements of selector
+func (sel Selector) Match(labels Labels) bool {
+	for _, r := range sel {
+		v, ok := labels[r.key]
+		switch r.op {
+		case "=":
+			if !ok || v != r.value {
+				return false
+			}
+		case "!=":
+			if ok && v == r.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		default:
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelector(t *testing.T) {
+	assert := assert.New(t)
+
+	labels := Labels{"zone": "sh", "role": "web"}
+	cases := map[string]bool{
+		"":                  true,
+		"zone=sh":           true,
+		"zone==sh":          true,
+		"zone=sh,role=web":  true,
+		"zone=sh,role=db":   false,
+		"zone!=bj":          true,
+		"zone!=sh":          false,
+		"role":              true,
+		"gpu":               false,
+		"!gpu":              true,
+		"!zone":             false,
+		" zone = sh , role": true,
+	}
+	for s, matched := range cases {
+		sel, err := ParseSelector(s)
+		if assert.NoError(err, s) {
+			assert.Equal(matched, sel.Match(labels), s)
+		}
+	}
+
+	for _, s := range []string{"=sh", "!=sh", "!"} {
+		_, err := ParseSelector(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestLabelsScan(t *testing.T) {
+	assert := assert.New(t)
+
+	var l Labels
+	assert.NoError(l.Scan(`{"zone":"sh"}`))
+	assert.Equal(Labels{"zone": "sh"}, l)
+	assert.NoError(l.Scan([]byte("")))
+	assert.Nil(l)
+
+	v, err := Labels{"zone": "sh"}.Value()
+	assert.NoError(err)
+	assert.Equal(`{"zone":"sh"}`, v)
+	v, err = Labels(nil).Value()
+	assert.NoError(err)
+	assert.Equal("", v)
+}
@@ -0,0 +1,17 @@
+package app
+
+// Deployment for kube deployment
+type Deployment struct {
+	Name      string `json:"name"`
+	ImageName string `json:"image_name"`
+	Image     string `json:"image"`
+	Replicas  int    `json:"replicas"`
+	Revision  int64  `json:"revision"`
+}
+
+// HPA for kube hpa
+type HPA struct {
+	Name        string `json:"name"`
+	MinReplicas int    `json:"min_replicas"`
+	MaxReplicas int    `json:"max_replicas"`
+}
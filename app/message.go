@@ -7,6 +7,9 @@ type NotifyMessage struct {
 	Event  string     `json:"event"`
 	AppID  string     `json:"app_id"`
 	Config *AppConfig `json:"config,omitempty"`
+	// Broadcast notifies all instances of app, instead of the instances
+	// matched the config only
+	Broadcast bool `json:"broadcast,omitempty"`
 }
 
 // WSMessage is websocket message structure
@@ -20,3 +23,11 @@ type WSMessageRaw struct {
 	Action  string          `json:"action"`
 	Payload json.RawMessage `json:"payload"`
 }
+
+// WatchResult is the long poll result of notify messages of app
+type WatchResult struct {
+	AppID string `json:"app_id"`
+	// LastID is the id of last event, it is the since of next long poll
+	LastID   int64           `json:"last_id"`
+	Messages []NotifyMessage `json:"messages"`
+}
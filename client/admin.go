@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+// SyncHead is the repository head after sync
+type SyncHead struct {
+	Name     string `json:"name"`
+	AppID    string `json:"app_id"`
+	CommitID string `json:"commit_id"`
+}
+
+// SyncResult is the result of sync
+type SyncResult struct {
+	AppIDs []string `json:"app_ids"`
+	Head   SyncHead `json:"head"`
+}
+
+// Sync pulls the config repository of app, or all apps if appID is empty
+func (c *DandelionClient) Sync(appID string) (*SyncResult, error) {
+	return c.SyncContext(context.Background(), appID)
+}
+
+// SyncContext is like Sync but with context
+func (c *DandelionClient) SyncContext(ctx context.Context, appID string) (*SyncResult, error) {
+	apiURI := APIPrefix + "/sync"
+	if appID != "" {
+		apiURI += "/" + url.PathEscape(appID)
+	}
+
+	var info SyncResult
+	err := c.postJSON(ctx, apiURI, nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListApps lists app ids
+func (c *DandelionClient) ListApps() ([]string, error) {
+	return c.ListAppsContext(context.Background())
+}
+
+// ListAppsContext is like ListApps but with context
+func (c *DandelionClient) ListAppsContext(ctx context.Context) ([]string, error) {
+	var info struct {
+		AppIDs []string `json:"app_ids"`
+	}
+	err := c.getJSON(ctx, APIPrefix+"/list", &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.AppIDs, nil
+}
+
+// ListConfigs lists published configs of app, newest first
+func (c *DandelionClient) ListConfigs(appID string) ([]app.AppConfig, error) {
+	return c.ListConfigsContext(context.Background(), appID)
+}
+
+// ListConfigsContext is like ListConfigs but with context
+func (c *DandelionClient) ListConfigsContext(ctx context.Context, appID string) ([]app.AppConfig, error) {
+	var info struct {
+		Configs []app.AppConfig `json:"configs"`
+	}
+	err := c.getJSON(ctx, APIPrefix+"/list/"+url.PathEscape(appID)+"/configs", &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Configs, nil
+}
+
+// ListCommits lists commits of app, newest first
+func (c *DandelionClient) ListCommits(appID string) ([]app.Commit, error) {
+	return c.ListCommitsContext(context.Background(), appID)
+}
+
+// ListCommitsContext is like ListCommits but with context
+func (c *DandelionClient) ListCommitsContext(ctx context.Context, appID string) ([]app.Commit, error) {
+	var info struct {
+		Commits []app.Commit `json:"commits"`
+	}
+	err := c.getJSON(ctx, APIPrefix+"/list/"+url.PathEscape(appID)+"/commits", &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Commits, nil
+}
+
+// ListInstances lists instances of app active in the last day, filtered by
+// the host label selector if not empty
+func (c *DandelionClient) ListInstances(appID, selector string) ([]app.Status, error) {
+	return c.ListInstancesContext(context.Background(), appID, selector)
+}
+
+// ListInstancesContext is like ListInstances but with context
+func (c *DandelionClient) ListInstancesContext(ctx context.Context, appID, selector string) ([]app.Status, error) {
+	apiURI := APIPrefix + "/list/" + url.PathEscape(appID) + "/instances"
+	if selector != "" {
+		apiURI += "?" + url.Values{"selector": {selector}}.Encode()
+	}
+
+	var info struct {
+		Instances []app.Status `json:"instances"`
+	}
+	err := c.getJSON(ctx, apiURI, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Instances, nil
+}
+
+// ListHosts lists hosts active in the last day, filtered by the label
+// selector if not empty
+func (c *DandelionClient) ListHosts(selector string) ([]app.Inventory, error) {
+	return c.ListHostsContext(context.Background(), selector)
+}
+
+// ListHostsContext is like ListHosts but with context
+func (c *DandelionClient) ListHostsContext(ctx context.Context, selector string) ([]app.Inventory, error) {
+	apiURI := APIPrefix + "/hosts"
+	if selector != "" {
+		apiURI += "?" + url.Values{"selector": {selector}}.Encode()
+	}
+
+	var info struct {
+		Hosts []app.Inventory `json:"hosts"`
+	}
+	err := c.getJSON(ctx, apiURI, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Hosts, nil
+}
+
+// Publish publishes the commit of app to instances matched by cfg, the host
+// and instance id of cfg can be glob patterns. Only the matched instances are
+// notified unless broadcast.
+func (c *DandelionClient) Publish(cfg *app.ClientConfig, commitID string, broadcast bool) (*app.AppConfig, error) {
+	return c.PublishContext(context.Background(), cfg, commitID, broadcast)
+}
+
+// PublishContext is like Publish but with context
+func (c *DandelionClient) PublishContext(ctx context.Context, cfg *app.ClientConfig, commitID string, broadcast bool) (*app.AppConfig, error) {
+	form := url.Values{}
+	form.Set("version", cfg.Version)
+	form.Set("host", cfg.Host)
+	form.Set("instance_id", cfg.InstanceID)
+	form.Set("commit_id", commitID)
+	if broadcast {
+		form.Set("broadcast", "1")
+	}
+
+	var info struct {
+		Config app.AppConfig `json:"config"`
+	}
+	err := c.postJSON(ctx, APIPrefix+"/publish/"+url.PathEscape(cfg.AppID), form, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info.Config, nil
+}
+
+// Rollback rolls back the published config of app, returns the rolled back
+// config. Only the instances matched or using the config are notified unless
+// broadcast.
+func (c *DandelionClient) Rollback(appID string, configID int64, broadcast bool) (*app.AppConfig, error) {
+	return c.RollbackContext(context.Background(), appID, configID, broadcast)
+}
+
+// RollbackContext is like Rollback but with context
+func (c *DandelionClient) RollbackContext(ctx context.Context, appID string, configID int64, broadcast bool) (*app.AppConfig, error) {
+	form := url.Values{}
+	form.Set("id", strconv.FormatInt(configID, 10))
+	if broadcast {
+		form.Set("broadcast", "1")
+	}
+
+	var info struct {
+		Config app.AppConfig `json:"config"`
+	}
+	err := c.postJSON(ctx, APIPrefix+"/rollback/"+url.PathEscape(appID), form, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info.Config, nil
+}
+
+// Check notifies instances of app to check their configs
+func (c *DandelionClient) Check(appID string) error {
+	return c.CheckContext(context.Background(), appID)
+}
+
+// CheckContext is like Check but with context
+func (c *DandelionClient) CheckContext(ctx context.Context, appID string) error {
+	var info interface{}
+	return c.postJSON(ctx, APIPrefix+"/check/"+url.PathEscape(appID), nil, &info)
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+func TestAdminAPI(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var posts int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+			assert.NoError(r.ParseForm())
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST " + APIPrefix + "/sync/test":
+			w.Write([]byte(`{"code":0,"info":{"app_ids":["test"],"head":{"name":"refs/heads/test","app_id":"test","commit_id":"abc"}}}`))
+		case "GET " + APIPrefix + "/list":
+			w.Write([]byte(`{"code":0,"info":{"app_ids":["a","b"]}}`))
+		case "GET " + APIPrefix + "/list/test/instances":
+			assert.Equal("zone=sh", r.URL.Query().Get("selector"))
+			w.Write([]byte(`{"code":0,"info":{"app_id":"test","instances":[{"app_id":"test","host":"h1","status":3}]}}`))
+		case "POST " + APIPrefix + "/publish/test":
+			assert.Equal("1.0", r.PostForm.Get("version"))
+			assert.Equal("h*", r.PostForm.Get("host"))
+			assert.Equal("*", r.PostForm.Get("instance_id"))
+			assert.Equal("1", r.PostForm.Get("broadcast"))
+			w.Write([]byte(`{"code":0,"info":{"app_id":"test","config":{"id":3,"app_id":"test","commit_id":"` + r.PostForm.Get("commit_id") + `"}}}`))
+		case "POST " + APIPrefix + "/rollback/test":
+			assert.Empty(r.PostForm.Get("broadcast"))
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"info":"sql: no rows in result set"}`))
+		case "POST " + APIPrefix + "/kube/setreplicas/web":
+			assert.Equal("3", r.PostForm.Get("replicas"))
+			w.Write([]byte(`{"code":0,"info":{"deployment":{"name":"web","replicas":3},"hpa":{"name":"web","min_replicas":3,"max_replicas":5},"ok":1}}`))
+		case "GET " + APIPrefix + "/kube/detail/web":
+			w.Write([]byte(`{"code":0,"info":{"deployment":{"name":"web","replicas":3},"hpa":null}}`))
+		case "POST " + APIPrefix + "/kube/restart/web":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"code":403,"info":"deployment is not managed by dandelion"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`bad gateway`))
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewDandelionClient(s.URL, true, WithRetry(RetryPolicy{MaxAttempts: 3}))
+	require.NoError(err)
+
+	r, err := c.Sync("test")
+	require.NoError(err)
+	assert.Equal([]string{"test"}, r.AppIDs)
+	assert.Equal("abc", r.Head.CommitID)
+
+	appIDs, err := c.ListApps()
+	require.NoError(err)
+	assert.Equal([]string{"a", "b"}, appIDs)
+
+	instances, err := c.ListInstances("test", "zone=sh")
+	require.NoError(err)
+	require.Len(instances, 1)
+	assert.Equal("h1", instances[0].Host)
+
+	cfg, err := c.Publish(&app.ClientConfig{AppID: "test", Version: "1.0", Host: "h*", InstanceID: "*"}, "abc", true)
+	require.NoError(err)
+	assert.Equal(int64(3), cfg.ID)
+	assert.Equal("abc", cfg.CommitID)
+
+	_, err = c.Rollback("test", 3, false)
+	var apiErr *APIError
+	require.True(errors.As(err, &apiErr))
+	assert.Equal(http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(404, apiErr.Code)
+	assert.Equal("sql: no rows in result set", apiErr.Message())
+	assert.True(IsNotFound(err))
+
+	d, h, err := c.KubeSetReplicas("web", 3)
+	require.NoError(err)
+	assert.Equal(3, d.Replicas)
+	assert.Equal(5, h.MaxReplicas)
+	d, h, err = c.KubeDetail("web")
+	require.NoError(err)
+	assert.Equal("web", d.Name)
+	assert.Nil(h)
+
+	_, err = c.KubeRestart("web")
+	assert.EqualError(err, "deployment is not managed by dandelion")
+	assert.False(IsNotFound(err))
+
+	// posts are not retried
+	atomic.StoreInt32(&posts, 0)
+	err = c.Check("test")
+	assert.EqualError(err, "HTTP 500")
+	assert.Equal(int32(1), atomic.LoadInt32(&posts))
+}
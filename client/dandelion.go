@@ -3,16 +3,20 @@ package client
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"path"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,13 +26,35 @@ import (
 // DandelionClient client interfaces
 type DandelionClient struct {
 	URL          string
+	endpoints    endpoints
+	wsEndpoint   *endpoint
 	conn         *websocket.Conn
+	dial         func() (*websocket.Conn, error)
 	closeCh      chan struct{}
-	notifyMsgCh  chan []byte
-	wsLock       *sync.Mutex
+	closeOnce    sync.Once
+	wsLock       sync.Mutex
+	statusLock   sync.Mutex
 	lastStatuses map[int]map[string]interface{}
+	state        int32
+	active       int64
+
+	watchLock sync.Mutex
+	watchers  map[*watcher]struct{}
+	pollWake  chan struct{}
+
+	httpClient *http.Client
+	transport  http.RoundTripper
+	tlsConfig  *tls.Config
+	authFunc   AuthFunc
+	timeout    time.Duration
+	retry      RetryPolicy
+	reconnect  RetryPolicy
 
 	notifyMsgHandler NotifyMessageHandler
+	inventoryLock    sync.Mutex
+	inventoryFunc    InventoryFunc
+	lastInventory    *app.Inventory
+	inventoryTime    time.Time
 }
 
 // DandelionResponse is the default dandelion restful API response structure
@@ -43,9 +69,15 @@ type InstanceStatus int
 // NotifyMessageHandler .
 type NotifyMessageHandler func(m *app.NotifyMessage)
 
+// InventoryFunc returns the current host inventory
+type InventoryFunc func() *app.Inventory
+
 const (
 	// APIPrefix is the prefix for the API URL
 	APIPrefix = "/api/v1"
+	// ChecksumHeader is the response header of hex encoded sha256 checksum
+	// of downloaded files and archives
+	ChecksumHeader = "X-Dandelion-Checksum"
 )
 
 // status
@@ -55,22 +87,56 @@ const (
 	StatusSyncing
 	StatusSuccess
 	StatusError
+	StatusDrifted
 )
 
-var errChannelClosed = errors.New("channel closed")
+var instanceStatusNames = []string{"offline", "checking", "syncing", "success", "error", "drifted"}
 
-// NewDandelionClient create new dandelion client instance
-func NewDandelionClient(serverURL string, syncOnly bool) (*DandelionClient, error) {
-	_, err := url.Parse(serverURL)
-	if err != nil {
-		return nil, err
+// String returns the name of instance status
+func (s InstanceStatus) String() string {
+	if s < 0 || int(s) >= len(instanceStatusNames) {
+		return "unknown"
 	}
+	return instanceStatusNames[s]
+}
+
+// NewDandelionClient create new dandelion client instance
+func NewDandelionClient(serverURL string, syncOnly bool, opts ...Option) (*DandelionClient, error) {
 	c := &DandelionClient{
 		URL:          serverURL,
+		endpoints:    endpoints{list: []*endpoint{{url: serverURL}}},
+		closeCh:      make(chan struct{}),
 		lastStatuses: make(map[int]map[string]interface{}),
+		watchers:     make(map[*watcher]struct{}),
+		pollWake:     make(chan struct{}, 1),
+		timeout:      DefaultTimeout,
+		reconnect: RetryPolicy{
+			Backoff:    DefaultReconnectMinBackoff,
+			MaxBackoff: DefaultReconnectMaxBackoff,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, ep := range c.endpoints.list {
+		_, err := url.Parse(ep.url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.httpClient == nil {
+		rt := c.transport
+		if rt == nil {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			if c.tlsConfig != nil {
+				t.TLSClientConfig = c.tlsConfig
+			}
+			rt = t
+		}
+		c.httpClient = &http.Client{Transport: rt}
 	}
 	if !syncOnly {
-		err = c.initWebSocket()
+		err := c.initWebSocket()
 		if err != nil {
 			return nil, err
 		}
@@ -78,10 +144,82 @@ func NewDandelionClient(serverURL string, syncOnly bool) (*DandelionClient, erro
 	return c, nil
 }
 
+// SetNotifyMessageHandler sets the handler of notify messages, it is called
+// in the websocket loop, use Watch for typed events instead
 func (c *DandelionClient) SetNotifyMessageHandler(h NotifyMessageHandler) {
 	c.notifyMsgHandler = h
 }
 
+// SetInventoryFunc sets the host inventory func, the inventory is reported
+// immediately and on connect, then along with pings only if it is changed
+// (except uptime) or not reported within an hour
+func (c *DandelionClient) SetInventoryFunc(f InventoryFunc) error {
+	c.inventoryLock.Lock()
+	c.inventoryFunc = f
+	c.lastInventory = nil
+	c.inventoryLock.Unlock()
+	return c.sendInventory()
+}
+
+// resetInventory forces the inventory to be reported by next ping
+func (c *DandelionClient) resetInventory() {
+	c.inventoryLock.Lock()
+	c.lastInventory = nil
+	c.inventoryLock.Unlock()
+}
+
+func (c *DandelionClient) sendInventory() error {
+	c.inventoryLock.Lock()
+	defer c.inventoryLock.Unlock()
+	if c.inventoryFunc == nil {
+		return nil
+	}
+	inv := c.inventoryFunc()
+	if sameInventory(c.lastInventory, inv) && time.Since(c.inventoryTime) < inventoryInterval {
+		return nil
+	}
+	message := app.WSMessage{
+		Action:  "inventory",
+		Payload: inv,
+	}
+	err := c.writeJSON(time.Now().Add(wsWriteTimeout), message)
+	if err != nil {
+		return err
+	}
+	c.lastInventory = inv
+	c.inventoryTime = time.Now()
+	return nil
+}
+
+// sameInventory returns whether the inventories are the same except uptime
+func sameInventory(a, b *app.Inventory) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, y := *a, *b
+	x.Uptime, y.Uptime = 0, 0
+	return reflect.DeepEqual(x, y)
+}
+
+// writeJSON writes message to websocket before deadline if not zero, it is
+// posted over http when long polling, or skipped if disconnected
+func (c *DandelionClient) writeJSON(deadline time.Time, message app.WSMessage) error {
+	c.wsLock.Lock()
+	if c.conn == nil {
+		c.wsLock.Unlock()
+		if c.State() == StatePolling {
+			return c.postMessage(deadline, message)
+		}
+		return nil
+	}
+	defer c.wsLock.Unlock()
+	if !deadline.IsZero() {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	return c.conn.WriteJSON(message)
+}
+
 func (c *DandelionClient) handleWebSocketMessage(msg []byte) {
 	var m app.NotifyMessage
 	err := json.Unmarshal(msg, &m)
@@ -89,53 +227,36 @@ func (c *DandelionClient) handleWebSocketMessage(msg []byte) {
 		clientLogger.Errorf("unknown notify message: %s", msg)
 		return
 	}
+	c.handleNotifyMessage(&m)
+}
+
+func (c *DandelionClient) handleNotifyMessage(m *app.NotifyMessage) {
+	c.broadcast(Event{Type: EventType(m.Event), AppID: m.AppID, Config: m.Config})
 	if c.notifyMsgHandler == nil {
 		return
 	}
-	c.notifyMsgHandler(&m)
+	c.notifyMsgHandler(m)
 }
 
-func (c *DandelionClient) serve() {
-	for {
-		select {
-		case _, ok := <-c.closeCh:
-			if !ok {
-				return
-			}
-		default:
-		}
-
-		t, msg, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
-				clientLogger.Errorf("unexpected close error: %v", err)
-			}
-			if c.notifyMsgCh != nil {
-				close(c.notifyMsgCh) // notify channel closed
-				c.notifyMsgCh = nil
-			}
-			break
-		}
-		if t == websocket.TextMessage || t == websocket.BinaryMessage {
-			clientLogger.Infof("received message: %s", msg)
-			c.notifyMsgCh <- msg
-		}
+func (c *DandelionClient) ping() error {
+	err := c.sendInventory()
+	if err != nil {
+		clientLogger.Errorf("websocket send inventory failed: %v", err)
+		return err
 	}
-}
 
-func (c *DandelionClient) ping() error {
 	var statuses []map[string]interface{}
+	c.statusLock.Lock()
 	for _, v := range c.lastStatuses {
 		statuses = append(statuses, v)
 	}
+	c.statusLock.Unlock()
 	message := app.WSMessage{
 		Action:  "ping",
 		Payload: statuses,
 	}
 
-	c.wsLock.Lock()
-	err := c.conn.WriteJSON(message)
-	c.wsLock.Unlock()
+	err = c.writeJSON(time.Now().Add(wsWriteTimeout), message)
 	if err != nil {
 		clientLogger.Errorf("websocket ping failed: %v", err)
 		return err
@@ -145,7 +266,65 @@ func (c *DandelionClient) ping() error {
 }
 
 func (c *DandelionClient) initWebSocket() error {
-	u, _ := url.Parse(c.URL)
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.timeout,
+		TLSClientConfig:  c.tlsConfig,
+	}
+	c.dial = func() (*websocket.Conn, error) {
+		ep := c.endpoints.pick()
+		u, headers := webSocketURL(ep.url, "/connect/push")
+		if c.authFunc != nil {
+			auth, err := c.authFunc(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			headers.Set("Authorization", auth)
+		}
+		conn, _, err := dialer.Dial(u, headers)
+		if isUpgradeRefused(err) {
+			// the server is reachable, long polls it instead
+			c.endpoints.markUp(ep)
+			c.wsEndpoint = ep
+			return nil, err
+		} else if err != nil {
+			c.endpoints.markDown(ep)
+			return nil, err
+		}
+		c.endpoints.markUp(ep)
+		c.wsEndpoint = ep
+		return conn, nil
+	}
+
+	c.setState(StateConnecting)
+	var conn *websocket.Conn
+	var err error
+	refused := false
+	// try each endpoint once
+	for i := 0; i < len(c.endpoints.list); i++ {
+		conn, err = c.dial()
+		if err == nil {
+			break
+		}
+		refused = refused || isUpgradeRefused(err)
+	}
+	if err != nil && !refused {
+		c.setState(StateDisconnected)
+		return err
+	}
+	if conn != nil {
+		c.setConn(conn)
+	} else {
+		clientLogger.Errorf("websocket upgrade refused by %s, falling back to long polling", c.wsEndpoint.url)
+	}
+	go c.run(conn, conn == nil)
+
+	return nil
+}
+
+// webSocketURL returns the websocket url of path and headers of server url
+func webSocketURL(serverURL, path string) (string, http.Header) {
+	u, _ := url.Parse(serverURL)
 
 	// websocket connect
 	if u.Scheme == "https" {
@@ -153,7 +332,7 @@ func (c *DandelionClient) initWebSocket() error {
 	} else {
 		u.Scheme = "ws"
 	}
-	u.Path += "/connect/push"
+	u.Path += path
 
 	headers := http.Header{}
 	headers.Add("User-Agent", UserAgent)
@@ -165,250 +344,421 @@ func (c *DandelionClient) initWebSocket() error {
 		}
 		u.User = nil
 	}
+	return u.String(), headers
+}
 
-	client, _, err := websocket.DefaultDialer.Dial(u.String(), headers)
-	if err != nil {
-		return err
+// run serves the connected websocket, or long polls if polling is true, and
+// keeps it connected until the client is closed, it reconnects with jittered
+// exponential backoff
+func (c *DandelionClient) run(conn *websocket.Conn, polling bool) {
+	for attempt := 0; ; {
+		if conn != nil {
+			attempt = 0
+			err := c.session(conn)
+			c.setConn(nil)
+			conn.Close()
+			if c.closed() {
+				return
+			}
+			clientLogger.Errorf("websocket disconnected: %v", err)
+			// reconnect to the next endpoint
+			c.endpoints.markDown(c.wsEndpoint)
+			c.broadcast(Event{Type: EventDisconnected, Err: err})
+		} else if polling {
+			attempt = 0
+			err := c.pollSession()
+			if c.closed() {
+				return
+			}
+			// endpoints of failed polls are marked down already
+			clientLogger.Errorf("long polling failed: %v", err)
+			c.setState(StateDisconnected)
+			c.broadcast(Event{Type: EventDisconnected, Err: err})
+		}
+
+		attempt++
+		wait := jitter(c.reconnect.backoff(attempt))
+		c.setActive()
+		select {
+		case <-time.After(wait):
+		case <-c.closeCh:
+			return
+		}
+
+		c.setState(StateConnecting)
+		var err error
+		conn, err = c.dial()
+		polling = isUpgradeRefused(err)
+		if polling {
+			clientLogger.Errorf("websocket upgrade refused by %s, falling back to long polling", c.wsEndpoint.url)
+			continue
+		} else if err != nil {
+			clientLogger.Errorf("websocket reconnect failed (attempt %d): %v", attempt, err)
+			c.setState(StateDisconnected)
+			conn = nil
+			continue
+		}
+		if !c.setConn(conn) {
+			// closed
+			return
+		}
+		clientLogger.Infof("websocket reconnected to %s", c.wsEndpoint.url)
 	}
-	c.wsLock = new(sync.Mutex)
-	c.closeCh = make(chan struct{})
-	c.notifyMsgCh = make(chan []byte, 20)
-	c.conn = client
-	connected := true
-	go c.serve()
+}
+
+// session serves the websocket connection until it fails or the client is
+// closed, the statuses are reported at start and every ping interval
+func (c *DandelionClient) session(conn *websocket.Conn) error {
+	msgCh := make(chan []byte)
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		// TODO: add context
 		for {
-			if c.notifyMsgCh == nil {
-				select {
-				case <-time.After(time.Minute * 2):
-					if connected {
-						err = c.ping()
-					}
-				case <-c.closeCh:
-					return
-				}
-			} else {
+			t, msg, err := conn.ReadMessage()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if t == websocket.TextMessage || t == websocket.BinaryMessage {
+				clientLogger.Infof("received message: %s", msg)
 				select {
-				case <-time.After(time.Minute * 2):
-					if connected {
-						err = c.ping()
-					}
-				case <-c.closeCh:
+				case msgCh <- msg:
+				case <-done:
 					return
-				case m, ok := <-c.notifyMsgCh:
-					if !ok {
-						if c.closeCh != nil {
-							// unexpected channel closed, cause closeCh should close first normally
-							err = errChannelClosed
-						} else {
-							return
-						}
-					} else {
-						c.handleWebSocketMessage(m)
-					}
-				}
-			}
-			if err != nil && c.closeCh != nil {
-				if connected {
-					c.wsLock.Lock()
-					c.conn.Close() // close explicitly
-					connected = false
-					c.wsLock.Unlock()
-				}
-				client, _, err = websocket.DefaultDialer.Dial(u.String(), headers)
-				if err == nil {
-					// reconnected
-					clientLogger.Infof("websocket reconnected")
-					c.wsLock.Lock()
-					c.conn = client
-					connected = true
-					c.wsLock.Unlock()
-
-					// reset notify message channel
-					if c.notifyMsgCh != nil {
-						close(c.notifyMsgCh)
-						c.notifyMsgCh = nil
-					}
-					c.notifyMsgCh = make(chan []byte, 20)
-
-					go c.serve()
 				}
 			}
 		}
 	}()
 
-	return nil
-}
-
-// Match found best match config from dandelion server
-func (c *DandelionClient) Match(clientConfig *app.ClientConfig) (*app.AppConfig, error) {
-	apiURI := APIPrefix + "/match/" + clientConfig.AppID
-
-	u := url.Values{}
-	u.Add("version", clientConfig.Version)
-	u.Add("host", clientConfig.Host)
-	u.Add("instance_id", clientConfig.InstanceID)
-
-	clientLogger.Debugf("GET %s?%s", apiURI, u.Encode())
-
-	req, err := http.NewRequest(http.MethodGet,
-		fmt.Sprintf("%s%s?%s", c.URL, apiURI, u.Encode()),
-		nil)
+	c.resetInventory()
+	err := c.ping()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	var resp DandelionResponse
-	err = DoHTTPRequest(req, true, &resp)
-	if err != nil {
-		return nil, err
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		c.setActive()
+		select {
+		case msg := <-msgCh:
+			c.handleWebSocketMessage(msg)
+		case <-ticker.C:
+			err = c.ping()
+			if err != nil {
+				return err
+			}
+		case err = <-errCh:
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+				clientLogger.Errorf("unexpected close error: %v", err)
+			}
+			return err
+		case <-c.closeCh:
+			return nil
+		}
 	}
+}
 
-	if resp.Code != 0 {
-		return nil, errors.New(string(resp.Info))
+// setConn sets current websocket connection, it returns false if the client
+// is closed
+func (c *DandelionClient) setConn(conn *websocket.Conn) bool {
+	c.wsLock.Lock()
+	if conn != nil && c.closed() {
+		c.wsLock.Unlock()
+		conn.Close()
+		return false
 	}
-
-	var info struct {
-		AppID  string        `json:"app_id"`
-		Config app.AppConfig `json:"config"`
+	c.conn = conn
+	c.wsLock.Unlock()
+	if conn == nil {
+		if !c.closed() {
+			c.setState(StateDisconnected)
+		}
+		return true
 	}
+	c.setState(StateConnected)
+	c.broadcast(Event{Type: EventConnected})
+	return true
+}
 
-	err = json.Unmarshal(resp.Info, &info)
-	if err != nil {
-		return nil, err
+func (c *DandelionClient) closed() bool {
+	select {
+	case <-c.closeCh:
+		return true
+	default:
+		return false
 	}
+}
 
-	return &info.Config, nil
+func (c *DandelionClient) setActive() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
 }
 
-// ListFiles list files for specified app id & commit id
-func (c *DandelionClient) ListFiles(appID string, commitID string) ([]string, error) {
-	apiURI := APIPrefix + "/list/" + appID + "/tree/" + commitID
+// Active returns the last time the websocket loop was active, the loop wakes
+// up at least every 2 minutes. It is zero if websocket is disabled.
+func (c *DandelionClient) Active() time.Time {
+	v := atomic.LoadInt64(&c.active)
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
 
-	clientLogger.Debugf("GET %s", apiURI)
+// Connected returns whether the websocket is connected to dandelion server,
+// or long polling it
+func (c *DandelionClient) Connected() bool {
+	s := c.State()
+	return s == StateConnected || s == StatePolling
+}
 
-	req, err := http.NewRequest(http.MethodGet,
-		fmt.Sprintf("%s%s", c.URL, apiURI),
-		nil)
-	if err != nil {
-		return nil, err
+// doOnce sends a request with form body if not nil to the dandelion server
+// url, returns the status code and body of response
+func (c *DandelionClient) doOnce(ctx context.Context, serverURL, method, apiURI string, form url.Values, isJSONResponse bool) (int, []byte, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
 	}
 
-	var resp DandelionResponse
-	err = DoHTTPRequest(req, true, &resp)
+	var reqBody io.Reader
+	if form != nil {
+		reqBody = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, serverURL+apiURI, reqBody)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
-	if resp.Code != 0 {
-		return nil, errors.New(string(resp.Info))
+	InitHTTPRequest(req, isJSONResponse)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
-
-	var info struct {
-		AppID    string   `json:"app_id"`
-		CommitID string   `json:"commit_id"`
-		Files    []string `json:"files"`
+	if c.authFunc != nil {
+		auth, err := c.authFunc(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set("Authorization", auth)
 	}
-	err = json.Unmarshal(resp.Info, &info)
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
-	return info.Files, nil
-}
+	// close response
+	defer resp.Body.Close()
 
-// GetZipArchive get zip archived commit files
-func (c *DandelionClient) GetZipArchive(appID, commitID string) (*zip.Reader, error) {
-	apiURI := APIPrefix + "/archive/" + appID + "/" + commitID + ".zip"
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
 
+// get sends a get request to dandelion server with the retry policy, each
+// attempt fails over all endpoints
+func (c *DandelionClient) get(ctx context.Context, apiURI string, isJSONResponse bool) (int, []byte, error) {
 	clientLogger.Debugf("GET %s", apiURI)
 
-	req, err := http.NewRequest(http.MethodGet,
-		fmt.Sprintf("%s%s", c.URL, apiURI),
-		nil)
-	if err != nil {
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		code, body, err := c.do(ctx, http.MethodGet, apiURI, nil, isJSONResponse)
+		if err == nil {
+			clientLogger.Debugf("HTTP %d %s", code, apiURI)
+		}
+		if (err == nil && code < http.StatusInternalServerError) || attempt >= c.retry.MaxAttempts || ctx.Err() != nil {
+			return code, body, err
+		}
+		if err == nil {
+			err = &APIError{StatusCode: code}
+		}
+		wait := c.retry.backoff(attempt)
+		clientLogger.Errorf("GET %s failed: %v, retry in %v", apiURI, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
 	}
+}
 
-	InitHTTPRequest(req, false)
+// post sends a post request with form to dandelion server, it is never
+// retried as the admin operations are not idempotent
+func (c *DandelionClient) post(ctx context.Context, apiURI string, form url.Values) (int, []byte, error) {
+	clientLogger.Debugf("POST %s", apiURI)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if form == nil {
+		form = url.Values{}
+	}
+	code, body, err := c.do(ctx, http.MethodPost, apiURI, form, true)
+	if err == nil {
+		clientLogger.Debugf("HTTP %d %s", code, apiURI)
 	}
+	return code, body, err
+}
 
-	// close response
-	defer resp.Body.Close()
+// APIError is the error response of dandelion server
+type APIError struct {
+	// StatusCode is the http status code of response
+	StatusCode int
+	// Code is the code of dandelion response
+	Code int
+	// Info is the info of dandelion response, usually the error message
+	Info json.RawMessage
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// Message returns the error message in info
+func (e *APIError) Message() string {
+	var s string
+	if json.Unmarshal(e.Info, &s) == nil {
+		return s
 	}
+	return string(e.Info)
+}
 
-	clientLogger.Debugf("HTTP %s", resp.Status)
+func (e *APIError) Error() string {
+	if len(e.Info) <= 0 {
+		return fmt.Sprintf("HTTP %d", e.StatusCode)
+	}
+	return e.Message()
+}
 
-	r := bytes.NewReader(body)
-	return zip.NewReader(r, r.Size())
+// IsNotFound returns whether err is a not found error response
+func IsNotFound(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
 }
 
-// Download remote file to local
-func (c *DandelionClient) Download(appID, commitID, remotePath, filePath string) error {
-	apiURI := APIPrefix + "/list/" + appID + "/tree/" + commitID + "/" + remotePath
+// decodeResponse decodes the info of dandelion response, it returns
+// *APIError for error responses
+func decodeResponse(code int, body []byte, v interface{}) error {
+	var resp DandelionResponse
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		if code != http.StatusOK {
+			return &APIError{StatusCode: code}
+		}
+		return err
+	}
 
-	clientLogger.Debugf("GET %s", apiURI)
+	if resp.Code != 0 || code >= http.StatusBadRequest {
+		return &APIError{StatusCode: code, Code: resp.Code, Info: resp.Info}
+	}
+
+	return json.Unmarshal(resp.Info, v)
+}
+
+// checkResponse returns *APIError if the non-json response is not ok
+func checkResponse(code int, body []byte) error {
+	if code == http.StatusOK {
+		return nil
+	}
+	var v interface{}
+	err := decodeResponse(code, body, &v)
+	if err == nil {
+		err = &APIError{StatusCode: code}
+	}
+	return err
+}
 
-	req, err := http.NewRequest(http.MethodGet,
-		fmt.Sprintf("%s%s", c.URL, apiURI),
-		nil)
+// getJSON sends a get request and decodes the info of response to v
+func (c *DandelionClient) getJSON(ctx context.Context, apiURI string, v interface{}) error {
+	code, body, err := c.get(ctx, apiURI, true)
 	if err != nil {
 		return err
 	}
+	return decodeResponse(code, body, v)
+}
 
-	InitHTTPRequest(req, false)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// postJSON sends a post request and decodes the info of response to v
+func (c *DandelionClient) postJSON(ctx context.Context, apiURI string, form url.Values, v interface{}) error {
+	code, body, err := c.post(ctx, apiURI, form)
 	if err != nil {
 		return err
 	}
+	return decodeResponse(code, body, v)
+}
 
-	// close response
-	defer resp.Body.Close()
+// Match found best match config from dandelion server
+func (c *DandelionClient) Match(clientConfig *app.ClientConfig) (*app.AppConfig, error) {
+	return c.MatchContext(context.Background(), clientConfig)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// MatchContext is like Match but with context
+func (c *DandelionClient) MatchContext(ctx context.Context, clientConfig *app.ClientConfig) (*app.AppConfig, error) {
+	u := url.Values{}
+	u.Add("version", clientConfig.Version)
+	u.Add("host", clientConfig.Host)
+	u.Add("instance_id", clientConfig.InstanceID)
+
+	apiURI := APIPrefix + "/match/" + clientConfig.AppID + "?" + u.Encode()
+
+	var info struct {
+		AppID  string        `json:"app_id"`
+		Config app.AppConfig `json:"config"`
+	}
+	err := c.getJSON(ctx, apiURI, &info)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	clientLogger.Debugf("HTTP %s\n%s", resp.Status, body)
+	return &info.Config, nil
+}
 
-	if resp.StatusCode != 200 {
-		var resp DandelionResponse
-		err = json.Unmarshal(body, &resp)
-		if err != nil {
-			return err
-		}
-		return errors.New(string(resp.Info))
+// ListFiles list files for specified app id & commit id
+func (c *DandelionClient) ListFiles(appID string, commitID string) ([]string, error) {
+	return c.ListFilesContext(context.Background(), appID, commitID)
+}
+
+// ListFilesContext is like ListFiles but with context
+func (c *DandelionClient) ListFilesContext(ctx context.Context, appID string, commitID string) ([]string, error) {
+	apiURI := APIPrefix + "/list/" + appID + "/tree/" + commitID
+
+	var info struct {
+		AppID    string   `json:"app_id"`
+		CommitID string   `json:"commit_id"`
+		Files    []string `json:"files"`
+	}
+	err := c.getJSON(ctx, apiURI, &info)
+	if err != nil {
+		return nil, err
 	}
 
-	err = os.MkdirAll(path.Dir(filePath), os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		return err
+	return info.Files, nil
+}
+
+// GetZipArchive get zip archived commit files, the archive is read into
+// memory, use OpenZipArchive for large archives
+func (c *DandelionClient) GetZipArchive(appID, commitID string) (*zip.Reader, error) {
+	return c.GetZipArchiveContext(context.Background(), appID, commitID)
+}
+
+// GetZipArchiveContext is like GetZipArchive but with context
+func (c *DandelionClient) GetZipArchiveContext(ctx context.Context, appID, commitID string) (*zip.Reader, error) {
+	apiURI := APIPrefix + "/archive/" + appID + "/" + commitID + ".zip"
+
+	code, body, err := c.get(ctx, apiURI, false)
+	if err != nil {
+		return nil, err
 	}
 
-	f, err := os.Create(filePath)
+	err = checkResponse(code, body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = f.Write(body)
-	return err
+	r := bytes.NewReader(body)
+	return zip.NewReader(r, r.Size())
 }
 
 // SetStatus set instance status
 func (c *DandelionClient) SetStatus(cfg *app.ClientConfig, status InstanceStatus, v ...interface{}) error {
+	return c.SetStatusContext(context.Background(), cfg, status, v...)
+}
+
+// SetStatusContext is like SetStatus, the deadline of context limits the
+// websocket write
+func (c *DandelionClient) SetStatusContext(ctx context.Context, cfg *app.ClientConfig, status InstanceStatus, v ...interface{}) error {
 	payload := map[string]interface{}{
 		"app_id":      cfg.AppID,
 		"host":        cfg.Host,
@@ -428,32 +778,36 @@ func (c *DandelionClient) SetStatus(cfg *app.ClientConfig, status InstanceStatus
 		Payload: payload,
 	}
 	// use app_id as key, save last status
+	c.statusLock.Lock()
 	c.lastStatuses[cfg.ID] = payload
+	c.statusLock.Unlock()
+	c.wakePoll()
 	clientLogger.Debugf("set status: %v", message)
 
-	if c.conn == nil {
-		return nil
-	}
+	deadline, _ := ctx.Deadline()
+	return c.writeJSON(deadline, message)
+}
 
-	c.wsLock.Lock()
-	defer c.wsLock.Unlock()
-	return c.conn.WriteJSON(message)
+// RemoveStatus removes the last status of specified config id, the status
+// will not be reported in ping anymore
+func (c *DandelionClient) RemoveStatus(id int) {
+	c.statusLock.Lock()
+	delete(c.lastStatuses, id)
+	c.statusLock.Unlock()
 }
 
-// Close connection to dandelion server
+// Close connection to dandelion server, watch channels are closed too
 func (c *DandelionClient) Close() error {
-	if c.conn == nil {
-		return nil
-	}
-	if c.closeCh != nil {
+	var err error
+	c.closeOnce.Do(func() {
 		close(c.closeCh)
-		c.closeCh = nil
-	}
-	if c.notifyMsgCh != nil {
-		close(c.notifyMsgCh)
-		c.notifyMsgCh = nil
-	}
-	err := c.conn.Close()
-	c.conn = nil
+		c.wsLock.Lock()
+		if c.conn != nil {
+			err = c.conn.Close()
+			c.conn = nil
+		}
+		c.wsLock.Unlock()
+		c.setState(StateClosed)
+	})
 	return err
 }
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+func TestClientOptions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case APIPrefix + "/match/hang":
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		case APIPrefix + "/match/flaky":
+			if n%3 != 0 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"code":0,"info":{"app_id":"flaky","config":{"id":1,"commit_id":"abc"}}}`))
+		case APIPrefix + "/match/auth":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"code":401,"info":"unauthorized"}`))
+				return
+			}
+			w.Write([]byte(`{"code":0,"info":{"app_id":"auth","config":{"id":2}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"info":"not found"}`))
+		}
+	}))
+	defer s.Close()
+
+	// timeout
+	c, err := NewDandelionClient(s.URL, true, WithTimeout(100*time.Millisecond))
+	require.NoError(err)
+	start := time.Now()
+	_, err = c.Match(&app.ClientConfig{AppID: "hang"})
+	assert.True(errors.Is(err, context.DeadlineExceeded), err)
+	assert.Less(int64(time.Since(start)), int64(time.Second))
+
+	// cancel
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err = NewDandelionClient(s.URL, true, WithTimeout(0))
+	require.NoError(err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err = c.MatchContext(ctx, &app.ClientConfig{AppID: "hang"})
+	assert.True(errors.Is(err, context.Canceled), err)
+
+	// retry
+	atomic.StoreInt32(&requests, 0)
+	c, err = NewDandelionClient(s.URL, true, WithRetry(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}))
+	require.NoError(err)
+	_, err = c.Match(&app.ClientConfig{AppID: "flaky"})
+	assert.EqualError(err, "HTTP 502")
+	atomic.StoreInt32(&requests, 0)
+	c, err = NewDandelionClient(s.URL, true, WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}))
+	require.NoError(err)
+	cfg, err := c.Match(&app.ClientConfig{AppID: "flaky"})
+	require.NoError(err)
+	assert.Equal("abc", cfg.CommitID)
+	assert.Equal(int32(3), atomic.LoadInt32(&requests))
+
+	// auth
+	_, err = c.Match(&app.ClientConfig{AppID: "auth"})
+	assert.EqualError(err, "unauthorized")
+	c, err = NewDandelionClient(s.URL, true,
+		WithHTTPClient(&http.Client{}),
+		WithAuth(func(ctx context.Context) (string, error) {
+			return "Bearer token", nil
+		}))
+	require.NoError(err)
+	cfg, err = c.Match(&app.ClientConfig{AppID: "auth"})
+	require.NoError(err)
+	assert.Equal(int64(2), cfg.ID)
+
+	// not found is not retried
+	atomic.StoreInt32(&requests, 0)
+	_, err = c.ListFilesContext(context.Background(), "test", "abc")
+	assert.EqualError(err, "not found")
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(time.Second, p.backoff(1))
+	assert.Equal(2*time.Second, p.backoff(2))
+	assert.Equal(4*time.Second, p.backoff(3))
+	assert.Equal(5*time.Second, p.backoff(4))
+	assert.Equal(5*time.Second, p.backoff(100))
+}
+
+func TestSendInventory(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	upgrader := websocket.Upgrader{}
+	conns := make(chan *websocket.Conn, 4)
+	messages := make(chan app.WSMessageRaw, 16)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+		for {
+			var m app.WSMessageRaw
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			messages <- m
+		}
+	}))
+	defer s.Close()
+
+	// inventories returns the hosts of inventories reported until next ping
+	inventories := func() []string {
+		var hosts []string
+		for {
+			select {
+			case m := <-messages:
+				if m.Action == "ping" {
+					return hosts
+				}
+				if m.Action == "inventory" {
+					var inv app.Inventory
+					require.NoError(json.Unmarshal(m.Payload, &inv))
+					hosts = append(hosts, inv.Host)
+				}
+			case <-time.After(5 * time.Second):
+				require.FailNow("ping timeout")
+			}
+		}
+	}
+
+	c, err := NewDandelionClient(s.URL, false, WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	require.NoError(err)
+	defer c.Close()
+	conn := <-conns
+	assert.Empty(inventories())
+
+	var mu sync.Mutex
+	inv := app.Inventory{Host: "h1", Labels: app.Labels{"zone": "sh"}}
+	require.NoError(c.SetInventoryFunc(func() *app.Inventory {
+		mu.Lock()
+		defer mu.Unlock()
+		inv.Uptime++
+		copied := inv
+		return &copied
+	}))
+	require.NoError(c.ping())
+	assert.Equal([]string{"h1"}, inventories())
+
+	// unchanged except uptime
+	require.NoError(c.ping())
+	assert.Empty(inventories())
+
+	mu.Lock()
+	inv.Labels = app.Labels{"zone": "bj"}
+	mu.Unlock()
+	require.NoError(c.ping())
+	assert.Equal([]string{"h1"}, inventories())
+	require.NoError(c.ping())
+	assert.Empty(inventories())
+
+	// reported again after interval
+	c.inventoryLock.Lock()
+	c.inventoryTime = time.Now().Add(-inventoryInterval)
+	c.inventoryLock.Unlock()
+	require.NoError(c.ping())
+	assert.Equal([]string{"h1"}, inventories())
+
+	// reported on reconnect
+	conn.Close()
+	<-conns
+	assert.Equal([]string{"h1"}, inventories())
+}
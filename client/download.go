@@ -0,0 +1,286 @@
+package client
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const partFileExt = ".part"
+
+// ErrChecksumMismatch is returned if the checksum of downloaded file does
+// not match the server's
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ZipArchive is a zip archive downloaded to a temp file, it should be closed
+// to remove the temp file
+type ZipArchive struct {
+	*zip.Reader
+	f *os.File
+}
+
+// Close closes and removes the temp file of archive
+func (a *ZipArchive) Close() error {
+	err := a.f.Close()
+	os.Remove(a.f.Name())
+	return err
+}
+
+// retryable returns whether the download error is temporary
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return err == ErrChecksumMismatch || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// download streams the response of apiURI to f, it resumes from the end of
+// f with range requests. Failed attempts which made progress are resumed
+// immediately, others fail over to the next endpoint, and then are retried
+// with the retry policy. The content is verified if the server responds the
+// checksum.
+func (c *DandelionClient) download(ctx context.Context, apiURI string, f *os.File) error {
+	clientLogger.Debugf("GET %s", apiURI)
+
+	var etag string
+	failovers := 0
+	for attempt := 1; ; {
+		n, err := c.downloadOnce(ctx, apiURI, f, &etag)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		if n > 0 && err != ErrChecksumMismatch {
+			clientLogger.Errorf("GET %s interrupted: %v, resuming", apiURI, err)
+			continue
+		}
+		if err != ErrChecksumMismatch && failovers < len(c.endpoints.list)-1 {
+			failovers++
+			clientLogger.Errorf("GET %s failed: %v, failing over", apiURI, err)
+			continue
+		}
+		failovers = 0
+		if attempt >= c.retry.MaxAttempts {
+			return err
+		}
+		wait := c.retry.backoff(attempt)
+		attempt++
+		clientLogger.Errorf("GET %s failed: %v, retry in %v", apiURI, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// downloadOnce downloads from the healthy endpoint, the endpoint is marked
+// down on temporary errors
+func (c *DandelionClient) downloadOnce(ctx context.Context, apiURI string, f *os.File, etag *string) (int64, error) {
+	ep := c.endpoints.pick()
+	n, err := c.downloadFrom(ctx, ep.url, apiURI, f, etag)
+	if err == nil {
+		c.endpoints.markUp(ep)
+	} else if ctx.Err() == nil && err != ErrChecksumMismatch && retryable(err) {
+		c.endpoints.markDown(ep)
+	}
+	return n, err
+}
+
+// downloadFrom sends a request of the rest content after the end of f to the
+// server url, it returns the bytes written to f
+func (c *DandelionClient) downloadFrom(ctx context.Context, serverURL, apiURI string, f *os.File, etag *string) (int64, error) {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURL+apiURI, nil)
+	if err != nil {
+		return 0, err
+	}
+	InitHTTPRequest(req, false)
+	if c.authFunc != nil {
+		auth, err := c.authFunc(ctx)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", auth)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if *etag != "" {
+			req.Header.Set("If-Range", *etag)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	clientLogger.Debugf("HTTP %d %s", resp.StatusCode, apiURI)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			// not resumable, restart
+			err = truncate(f)
+			if err != nil {
+				return 0, err
+			}
+		}
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return 0, fmt.Errorf("unexpected content range: %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is stale, restart
+		err = truncate(f)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return c.downloadFrom(ctx, serverURL, apiURI, f, etag)
+	default:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		return 0, checkResponse(resp.StatusCode, body)
+	}
+
+	*etag = resp.Header.Get("ETag")
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return n, err
+	}
+
+	sum := resp.Header.Get(ChecksumHeader)
+	if sum == "" {
+		return n, nil
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return n, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return n, err
+	}
+	if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum) {
+		clientLogger.Errorf("GET %s checksum mismatch, expected %s", apiURI, sum)
+		err = truncate(f)
+		if err != nil {
+			return n, err
+		}
+		if offset > 0 {
+			// the partial file may be of other content, restart
+			resp.Body.Close()
+			return c.downloadFrom(ctx, serverURL, apiURI, f, etag)
+		}
+		return n, ErrChecksumMismatch
+	}
+	return n, nil
+}
+
+func truncate(f *os.File) error {
+	err := f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// OpenZipArchive downloads the zip archived commit files to a temp file, it
+// is preferred over GetZipArchive for large archives
+func (c *DandelionClient) OpenZipArchive(appID, commitID string) (*ZipArchive, error) {
+	return c.OpenZipArchiveContext(context.Background(), appID, commitID)
+}
+
+// OpenZipArchiveContext is like OpenZipArchive but with context
+func (c *DandelionClient) OpenZipArchiveContext(ctx context.Context, appID, commitID string) (*ZipArchive, error) {
+	apiURI := APIPrefix + "/archive/" + appID + "/" + commitID + ".zip"
+
+	f, err := ioutil.TempFile("", "dandelion-archive-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	a := &ZipArchive{f: f}
+	err = c.download(ctx, apiURI, f)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.Reader, err = zip.NewReader(f, size)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Download remote file to local
+func (c *DandelionClient) Download(appID, commitID, remotePath, filePath string) error {
+	return c.DownloadContext(context.Background(), appID, commitID, remotePath, filePath)
+}
+
+// DownloadContext is like Download but with context. The file is streamed
+// to filePath with the ".part" suffix and renamed after verified, the
+// partial file is resumed by later downloads.
+func (c *DandelionClient) DownloadContext(ctx context.Context, appID, commitID, remotePath, filePath string) error {
+	apiURI := APIPrefix + "/list/" + appID + "/tree/" + commitID + "/" + remotePath
+
+	err := os.MkdirAll(path.Dir(filePath), os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	partPath := filePath + partFileExt
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	err = c.download(ctx, apiURI, f)
+	if err != nil {
+		fi, statErr := f.Stat()
+		f.Close()
+		if statErr == nil && fi.Size() == 0 {
+			os.Remove(partPath)
+		}
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(partPath, filePath)
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// abortWriter aborts the response after limit bytes written
+type abortWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *abortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestDownload(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	content := []byte(strings.Repeat("dandelion config\n", 4096))
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("conf/app.yml")
+	fw.Write(content)
+	zw.Close()
+	archive := buf.Bytes()
+
+	var (
+		mu        sync.Mutex
+		ranges    []string
+		abortNext bool
+	)
+	serve := func(w http.ResponseWriter, r *http.Request, data []byte) {
+		h := sha256.Sum256(data)
+		sum := hex.EncodeToString(h[:])
+		w.Header().Set("ETag", `"`+sum+`"`)
+		w.Header().Set(ChecksumHeader, sum)
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		abort := abortNext
+		abortNext = false
+		mu.Unlock()
+		if abort {
+			w = &abortWriter{ResponseWriter: w, limit: len(data) / 2}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case APIPrefix + "/list/test/tree/c1/conf/app.yml":
+			serve(w, r, content)
+		case APIPrefix + "/archive/test/c1.zip":
+			serve(w, r, archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"info":"file not found"}`))
+		}
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "dandelion-download")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "conf", "app.yml")
+
+	c, err := NewDandelionClient(s.URL, true)
+	require.NoError(err)
+
+	// resume after interrupted
+	abortNext = true
+	require.NoError(c.Download("test", "c1", "conf/app.yml", filePath))
+	data, err := ioutil.ReadFile(filePath)
+	require.NoError(err)
+	assert.Equal(content, data)
+	require.Len(ranges, 2)
+	assert.Equal("", ranges[0])
+	assert.True(strings.HasPrefix(ranges[1], "bytes="), ranges[1])
+	_, err = os.Stat(filePath + partFileExt)
+	assert.True(os.IsNotExist(err))
+
+	// stale partial file
+	ranges = nil
+	require.NoError(ioutil.WriteFile(filePath+partFileExt, []byte("stale content"), 0644))
+	require.NoError(c.Download("test", "c1", "conf/app.yml", filePath))
+	data, err = ioutil.ReadFile(filePath)
+	require.NoError(err)
+	assert.Equal(content, data)
+	assert.Equal([]string{"bytes=13-", ""}, ranges)
+
+	// error response
+	err = c.Download("test", "c1", "missing.yml", filepath.Join(dir, "missing.yml"))
+	assert.True(IsNotFound(err))
+	assert.EqualError(err, "file not found")
+	_, err = os.Stat(filepath.Join(dir, "missing.yml"+partFileExt))
+	assert.True(os.IsNotExist(err))
+
+	// archive
+	abortNext = true
+	z, err := c.OpenZipArchive("test", "c1")
+	require.NoError(err)
+	require.Len(z.File, 1)
+	assert.Equal("conf/app.yml", z.File[0].Name)
+	tmpPath := z.f.Name()
+	require.NoError(z.Close())
+	_, err = os.Stat(tmpPath)
+	assert.True(os.IsNotExist(err))
+
+	_, err = c.OpenZipArchive("test", "c2")
+	assert.True(IsNotFound(err))
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// endpointDownTime is the duration an endpoint is skipped after failed
+	endpointDownTime = 30 * time.Second
+)
+
+// endpoint is a dandelion server url with its health
+type endpoint struct {
+	url       string
+	downUntil time.Time
+}
+
+// endpoints selects the healthy server url, it sticks to the current one
+// until it fails
+type endpoints struct {
+	lock sync.Mutex
+	list []*endpoint
+	cur  int
+}
+
+// WithEndpoints adds fallback server urls, requests and websocket fail over
+// to the next healthy url on connection errors or 5xx responses
+func WithEndpoints(urls ...string) Option {
+	return func(c *DandelionClient) {
+		for _, u := range urls {
+			c.endpoints.list = append(c.endpoints.list, &endpoint{url: u})
+		}
+	}
+}
+
+// pick returns the current endpoint if healthy, or the next healthy one. The
+// endpoint recovers first is returned if all endpoints are down.
+func (e *endpoints) pick() *endpoint {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	now := time.Now()
+	best := e.cur
+	for i := 0; i < len(e.list); i++ {
+		j := (e.cur + i) % len(e.list)
+		if !now.Before(e.list[j].downUntil) {
+			best = j
+			break
+		}
+		if e.list[j].downUntil.Before(e.list[best].downUntil) {
+			best = j
+		}
+	}
+	e.cur = best
+	return e.list[best]
+}
+
+// markDown skips the endpoint for a while, it returns whether other
+// endpoints are available
+func (e *endpoints) markDown(ep *endpoint) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ep.downUntil = time.Now().Add(endpointDownTime)
+	return len(e.list) > 1
+}
+
+// markUp marks the endpoint healthy
+func (e *endpoints) markUp(ep *endpoint) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ep.downUntil = time.Time{}
+}
+
+// Endpoint returns the current server url
+func (c *DandelionClient) Endpoint() string {
+	return c.endpoints.pick().url
+}
+
+// isConnError returns whether err is a connection error, the request was
+// not sent to server if dial is true
+func isConnError(err error, dial bool) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return !dial || opErr.Op == "dial"
+	}
+	var netErr net.Error
+	return !dial && (errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// do sends the request to healthy endpoints, it fails over on connection
+// errors or 5xx responses. Requests which are not idempotent fail over only
+// if they are not sent.
+func (c *DandelionClient) do(ctx context.Context, method, apiURI string, form url.Values, isJSONResponse bool) (int, []byte, error) {
+	idempotent := method == http.MethodGet
+	for i := 0; ; i++ {
+		ep := c.endpoints.pick()
+		code, body, err := c.doOnce(ctx, ep.url, method, apiURI, form, isJSONResponse)
+		if ctx.Err() != nil {
+			return code, body, err
+		}
+		failed := (err != nil && isConnError(err, !idempotent)) ||
+			(err == nil && idempotent && code >= http.StatusInternalServerError)
+		if !failed {
+			c.endpoints.markUp(ep)
+			return code, body, err
+		}
+		if !c.endpoints.markDown(ep) || i+1 >= len(c.endpoints.list) {
+			return code, body, err
+		}
+		if err == nil {
+			err = &APIError{StatusCode: code}
+		}
+		clientLogger.Errorf("%s %s%s failed: %v, failing over", method, ep.url, apiURI, err)
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	var failing, checks int32
+	upgrader := websocket.Upgrader{}
+	type wsConn struct {
+		*websocket.Conn
+		server string
+	}
+	conns := make(chan wsConn, 4)
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/connect/push":
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				conns <- wsConn{conn, name}
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			case APIPrefix + "/match/test":
+				if name == "s1" && atomic.LoadInt32(&failing) != 0 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.Write([]byte(`{"code":0,"info":{"app_id":"test","config":{"id":1,"commit_id":"` + name + `"}}}`))
+			case APIPrefix + "/check/test":
+				atomic.AddInt32(&checks, 1)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"code":500,"info":"internal error"}`))
+			}
+		}))
+	}
+	s1 := newServer("s1")
+	defer s1.Close()
+	s2 := newServer("s2")
+	defer s2.Close()
+
+	c, err := NewDandelionClient(down.URL, false,
+		WithEndpoints(s1.URL, s2.URL),
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	require.NoError(err)
+	defer c.Close()
+
+	// websocket skips the down endpoint
+	conn := <-conns
+	assert.Equal("s1", conn.server)
+	assert.Equal(s1.URL, c.Endpoint())
+	events, err := c.Watch(context.Background(), "")
+	require.NoError(err)
+
+	cfg, err := c.Match(&app.ClientConfig{AppID: "test"})
+	require.NoError(err)
+	assert.Equal("s1", cfg.CommitID)
+
+	// fail over on 5xx
+	atomic.StoreInt32(&failing, 1)
+	cfg, err = c.Match(&app.ClientConfig{AppID: "test"})
+	require.NoError(err)
+	assert.Equal("s2", cfg.CommitID)
+	assert.Equal(s2.URL, c.Endpoint())
+
+	// posts are not failed over on error responses
+	err = c.Check("test")
+	assert.EqualError(err, "internal error")
+	assert.Equal(int32(1), atomic.LoadInt32(&checks))
+
+	// websocket reconnects to the healthy endpoint
+	conn.Close()
+	assert.Equal(EventDisconnected, nextEvent(t, events).Type)
+	assert.Equal("s2", (<-conns).server)
+	assert.Equal(EventConnected, nextEvent(t, events).Type)
+}
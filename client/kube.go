@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tengattack/dandelion/app"
+)
+
+const (
+	kubeEventsHeartbeat         = "❤️"
+	kubeEventsHeartbeatInterval = 30 * time.Second
+)
+
+// KubeImageTags is the image tags of kube deployment
+type KubeImageTags struct {
+	ImageName string   `json:"image_name"`
+	Tags      []string `json:"tags"`
+}
+
+// KubeDeploymentStatus is the replicas status of kube deployment
+type KubeDeploymentStatus struct {
+	ObservedGeneration  int64 `json:"observedGeneration,omitempty"`
+	Replicas            int32 `json:"replicas,omitempty"`
+	UpdatedReplicas     int32 `json:"updatedReplicas,omitempty"`
+	ReadyReplicas       int32 `json:"readyReplicas,omitempty"`
+	AvailableReplicas   int32 `json:"availableReplicas,omitempty"`
+	UnavailableReplicas int32 `json:"unavailableReplicas,omitempty"`
+}
+
+// KubeEvent is the rollout event of kube deployment, the event is one of
+// processing, complete and timeout
+type KubeEvent struct {
+	Name   string                `json:"name"`
+	Action string                `json:"action"`
+	Event  string                `json:"event"`
+	Status *KubeDeploymentStatus `json:"status"`
+}
+
+type kubeDeploymentInfo struct {
+	Deployment *app.Deployment `json:"deployment"`
+	HPA        *app.HPA        `json:"hpa"`
+}
+
+func kubeURI(action, deployment string) string {
+	return APIPrefix + "/kube/" + action + "/" + url.PathEscape(deployment)
+}
+
+// KubeList lists deployments managed by dandelion
+func (c *DandelionClient) KubeList() ([]*app.Deployment, error) {
+	return c.KubeListContext(context.Background())
+}
+
+// KubeListContext is like KubeList but with context
+func (c *DandelionClient) KubeListContext(ctx context.Context) ([]*app.Deployment, error) {
+	var info struct {
+		Deployments []*app.Deployment `json:"deployments"`
+	}
+	err := c.getJSON(ctx, APIPrefix+"/kube/list", &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Deployments, nil
+}
+
+// KubeDetail gets the deployment and its hpa, the hpa is nil if not exists
+func (c *DandelionClient) KubeDetail(deployment string) (*app.Deployment, *app.HPA, error) {
+	return c.KubeDetailContext(context.Background(), deployment)
+}
+
+// KubeDetailContext is like KubeDetail but with context
+func (c *DandelionClient) KubeDetailContext(ctx context.Context, deployment string) (*app.Deployment, *app.HPA, error) {
+	var info kubeDeploymentInfo
+	err := c.getJSON(ctx, kubeURI("detail", deployment), &info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info.Deployment, info.HPA, nil
+}
+
+// KubeListTags lists image tags of the deployment from registry
+func (c *DandelionClient) KubeListTags(deployment string) (*KubeImageTags, error) {
+	return c.KubeListTagsContext(context.Background(), deployment)
+}
+
+// KubeListTagsContext is like KubeListTags but with context
+func (c *DandelionClient) KubeListTagsContext(ctx context.Context, deployment string) (*KubeImageTags, error) {
+	var info KubeImageTags
+	err := c.getJSON(ctx, kubeURI("listtags", deployment), &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// KubeSetVersionTag sets the image tag of the deployment
+func (c *DandelionClient) KubeSetVersionTag(deployment, tag string) (*app.Deployment, error) {
+	return c.KubeSetVersionTagContext(context.Background(), deployment, tag)
+}
+
+// KubeSetVersionTagContext is like KubeSetVersionTag but with context
+func (c *DandelionClient) KubeSetVersionTagContext(ctx context.Context, deployment, tag string) (*app.Deployment, error) {
+	form := url.Values{}
+	form.Set("version_tag", tag)
+
+	var info kubeDeploymentInfo
+	err := c.postJSON(ctx, kubeURI("setversiontag", deployment), form, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Deployment, nil
+}
+
+// KubeSetReplicas sets the replicas of the deployment, and the min replicas
+// of its hpa if exists
+func (c *DandelionClient) KubeSetReplicas(deployment string, replicas int) (*app.Deployment, *app.HPA, error) {
+	return c.KubeSetReplicasContext(context.Background(), deployment, replicas)
+}
+
+// KubeSetReplicasContext is like KubeSetReplicas but with context
+func (c *DandelionClient) KubeSetReplicasContext(ctx context.Context, deployment string, replicas int) (*app.Deployment, *app.HPA, error) {
+	form := url.Values{}
+	form.Set("replicas", strconv.Itoa(replicas))
+
+	var info kubeDeploymentInfo
+	err := c.postJSON(ctx, kubeURI("setreplicas", deployment), form, &info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info.Deployment, info.HPA, nil
+}
+
+// KubeRollback rolls back the deployment to the previous revision
+func (c *DandelionClient) KubeRollback(deployment string) (*app.Deployment, error) {
+	return c.KubeRollbackContext(context.Background(), deployment)
+}
+
+// KubeRollbackContext is like KubeRollback but with context
+func (c *DandelionClient) KubeRollbackContext(ctx context.Context, deployment string) (*app.Deployment, error) {
+	var info kubeDeploymentInfo
+	err := c.postJSON(ctx, kubeURI("rollback", deployment), nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Deployment, nil
+}
+
+// KubeRestart restarts pods of the deployment
+func (c *DandelionClient) KubeRestart(deployment string) (*app.Deployment, error) {
+	return c.KubeRestartContext(context.Background(), deployment)
+}
+
+// KubeRestartContext is like KubeRestart but with context
+func (c *DandelionClient) KubeRestartContext(ctx context.Context, deployment string) (*app.Deployment, error) {
+	var info kubeDeploymentInfo
+	err := c.postJSON(ctx, kubeURI("restart", deployment), nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info.Deployment, nil
+}
+
+// KubeEvents tails rollout events of the deployment, the channel is closed
+// when ctx is done or disconnected
+func (c *DandelionClient) KubeEvents(ctx context.Context, deployment string) (<-chan KubeEvent, error) {
+	u, headers := webSocketURL(c.endpoints.pick().url, "/events/kube/"+url.PathEscape(deployment))
+	if c.authFunc != nil {
+		auth, err := c.authFunc(ctx)
+		if err != nil {
+			return nil, err
+		}
+		headers.Set("Authorization", auth)
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.timeout,
+		TLSClientConfig:  c.tlsConfig,
+	}
+	conn, _, err := dialer.DialContext(ctx, u, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan KubeEvent, watchBufferSize)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(kubeEventsHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				conn.WriteMessage(websocket.TextMessage, []byte(kubeEventsHeartbeat))
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(ch)
+		defer conn.Close()
+		defer close(done)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == kubeEventsHeartbeat {
+				continue
+			}
+			var e KubeEvent
+			err = json.Unmarshal(msg, &e)
+			if err != nil {
+				clientLogger.Errorf("unknown kube event: %s", msg)
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the default timeout of each http request
+	DefaultTimeout = time.Minute
+)
+
+// AuthFunc returns the Authorization header value for requests, it is called
+// for each http request and websocket dial
+type AuthFunc func(ctx context.Context) (string, error)
+
+// RetryPolicy is the retry policy of idempotent http requests, requests are
+// retried on network errors and 5xx responses
+type RetryPolicy struct {
+	// MaxAttempts is the max attempts including the first request, no retry
+	// if it is less than 2
+	MaxAttempts int
+	// Backoff is the wait duration before the first retry, it doubles for
+	// each following retry
+	Backoff time.Duration
+	// MaxBackoff limits the wait duration between retries if positive
+	MaxBackoff time.Duration
+}
+
+// backoff returns the wait duration before the retry of attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Option configures DandelionClient
+type Option func(c *DandelionClient)
+
+// WithHTTPClient uses the http client for http requests, the transport and
+// TLS config options are ignored for http requests
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *DandelionClient) {
+		c.httpClient = hc
+	}
+}
+
+// WithTransport uses the round tripper for http requests, the TLS config
+// option is ignored for http requests
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *DandelionClient) {
+		c.transport = rt
+	}
+}
+
+// WithTLSConfig uses the TLS config for http requests and websocket
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *DandelionClient) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithAuth sets the Authorization header of requests by f, it overrides the
+// basic auth from user info of server url
+func WithAuth(f AuthFunc) Option {
+	return func(c *DandelionClient) {
+		c.authFunc = f
+	}
+}
+
+// WithTimeout sets the timeout of each http request and websocket
+// handshake, 0 means no timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *DandelionClient) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetry sets the retry policy of idempotent http requests
+func WithRetry(p RetryPolicy) Option {
+	return func(c *DandelionClient) {
+		c.retry = p
+	}
+}
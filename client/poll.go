@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tengattack/dandelion/app"
+)
+
+const (
+	// pollTimeout is the wait duration of each long poll, it is limited to
+	// half of the request timeout
+	pollTimeout = 30 * time.Second
+)
+
+// isUpgradeRefused returns whether the websocket dial failed as the server
+// or proxies refused to upgrade
+func isUpgradeRefused(err error) bool {
+	return errors.Is(err, websocket.ErrBadHandshake)
+}
+
+// wakePoll notifies the poll session to update the polled apps
+func (c *DandelionClient) wakePoll() {
+	select {
+	case c.pollWake <- struct{}{}:
+	default:
+	}
+}
+
+// pollApps returns the apps to long poll, which have statuses or watchers
+func (c *DandelionClient) pollApps() map[string]bool {
+	apps := make(map[string]bool)
+	c.statusLock.Lock()
+	for _, s := range c.lastStatuses {
+		if appID, ok := s["app_id"].(string); ok && appID != "" {
+			apps[appID] = true
+		}
+	}
+	c.statusLock.Unlock()
+	c.watchLock.Lock()
+	for w := range c.watchers {
+		if w.appID != "" {
+			apps[w.appID] = true
+		}
+	}
+	c.watchLock.Unlock()
+	return apps
+}
+
+// postMessage sends the websocket message over http before deadline if not
+// zero, it is used when long polling
+func (c *DandelionClient) postMessage(deadline time.Time, message app.WSMessage) error {
+	payload, err := json.Marshal(message.Payload)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("action", message.Action)
+	form.Set("payload", string(payload))
+
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	var info interface{}
+	return c.postJSON(ctx, APIPrefix+"/status", form, &info)
+}
+
+// pollApp long polls notify messages of app to msgCh until ctx done, the
+// error is sent to errCh if failed
+func (c *DandelionClient) pollApp(ctx context.Context, appID string, msgCh chan<- app.NotifyMessage, errCh chan<- error) {
+	wait := pollTimeout
+	if c.timeout > 0 && wait > c.timeout/2 {
+		wait = c.timeout / 2
+	}
+	since := ""
+	for {
+		u := url.Values{}
+		u.Set("timeout", wait.String())
+		if since != "" {
+			u.Set("since", since)
+		}
+		var r app.WatchResult
+		err := c.getJSON(ctx, APIPrefix+"/watch/"+url.PathEscape(appID)+"?"+u.Encode(), &r)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			select {
+			case errCh <- err:
+			default:
+			}
+			return
+		}
+		for _, m := range r.Messages {
+			select {
+			case msgCh <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+		since = strconv.FormatInt(r.LastID, 10)
+	}
+}
+
+// pollSession long polls notify messages of apps which have statuses or
+// watchers, until a poll fails or the client is closed. The statuses are
+// reported over http meanwhile.
+func (c *DandelionClient) pollSession() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	c.setState(StatePolling)
+	c.broadcast(Event{Type: EventConnected})
+
+	c.resetInventory()
+	err := c.ping()
+	if err != nil {
+		return err
+	}
+
+	// messages are handled in the session loop like websocket
+	msgCh := make(chan app.NotifyMessage)
+	errCh := make(chan error, 1)
+	pollers := make(map[string]context.CancelFunc)
+	update := func() {
+		apps := c.pollApps()
+		for appID, stop := range pollers {
+			if !apps[appID] {
+				stop()
+				delete(pollers, appID)
+			}
+		}
+		for appID := range apps {
+			if _, ok := pollers[appID]; ok {
+				continue
+			}
+			pctx, stop := context.WithCancel(ctx)
+			pollers[appID] = stop
+			wg.Add(1)
+			go func(appID string) {
+				defer wg.Done()
+				c.pollApp(pctx, appID, msgCh, errCh)
+			}(appID)
+		}
+	}
+	update()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		c.setActive()
+		select {
+		case m := <-msgCh:
+			clientLogger.Infof("received message: %s of %s", m.Event, m.AppID)
+			c.handleNotifyMessage(&m)
+		case <-c.pollWake:
+			update()
+		case <-ticker.C:
+			update()
+			err = c.ping()
+			if err != nil {
+				return err
+			}
+		case err = <-errCh:
+			return err
+		case <-c.closeCh:
+			return nil
+		}
+	}
+}
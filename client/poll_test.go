@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+func TestLongPoll(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var published int32
+	statuses := make(chan string, 8)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/connect/push":
+			// upgrade blocked by proxy
+			w.WriteHeader(http.StatusForbidden)
+		case APIPrefix + "/status":
+			assert.NoError(r.ParseForm())
+			statuses <- r.PostForm.Get("action") + " " + r.PostForm.Get("payload")
+			w.Write([]byte(`{"code":0,"info":"success"}`))
+		case APIPrefix + "/watch/s1":
+			assert.Equal("15s", r.URL.Query().Get("timeout"))
+			switch r.URL.Query().Get("since") {
+			case "":
+				w.Write([]byte(`{"code":0,"info":{"app_id":"s1","last_id":1,"messages":[]}}`))
+			case "1":
+				if atomic.CompareAndSwapInt32(&published, 0, 1) {
+					w.Write([]byte(`{"code":0,"info":{"app_id":"s1","last_id":3,"messages":[{"event":"publish","app_id":"s1","config":{"id":2,"commit_id":"abc"}}]}}`))
+					return
+				}
+				fallthrough
+			default:
+				select {
+				case <-r.Context().Done():
+				case <-time.After(100 * time.Millisecond):
+				}
+				w.Write([]byte(`{"code":0,"info":{"app_id":"s1","last_id":3,"messages":[]}}`))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewDandelionClient(s.URL, false, WithTimeout(30*time.Second))
+	require.NoError(err)
+	defer c.Close()
+
+	ch, err := c.Watch(context.Background(), "s1")
+	require.NoError(err)
+	for {
+		e := nextEvent(t, ch)
+		if e.Type == EventConnected {
+			continue
+		}
+		assert.Equal(EventPublish, e.Type)
+		assert.Equal("s1", e.AppID)
+		assert.Equal("abc", e.Config.CommitID)
+		break
+	}
+	assert.Equal(StatePolling, c.State())
+	assert.True(c.Connected())
+	assert.Equal("ping null", <-statuses)
+
+	// statuses are reported over http
+	err = c.SetStatus(&app.ClientConfig{ID: 2, AppID: "s1", Host: "h1", InstanceID: "i1"}, StatusSuccess)
+	require.NoError(err)
+	assert.Equal(`status {"app_id":"s1","host":"h1","instance_id":"i1","status":3}`, <-statuses)
+
+	require.NoError(c.Close())
+	assert.Equal(StateClosed, c.State())
+}
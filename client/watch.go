@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+const (
+	// DefaultReconnectMinBackoff is the default wait duration before the first
+	// websocket reconnection
+	DefaultReconnectMinBackoff = time.Second
+	// DefaultReconnectMaxBackoff is the default max wait duration between
+	// websocket reconnections
+	DefaultReconnectMaxBackoff = 2 * time.Minute
+
+	wsPingInterval  = 2 * time.Minute
+	wsWriteTimeout  = 10 * time.Second
+	watchBufferSize = 64
+	// unchanged inventory is reported again after interval, to keep the
+	// host active on server
+	inventoryInterval = time.Hour
+)
+
+// errors
+var (
+	ErrWebSocketDisabled = errors.New("websocket is disabled")
+	ErrClientClosed      = errors.New("client is closed")
+)
+
+// EventType is the type of watch event
+type EventType string
+
+// event types
+const (
+	EventPublish      EventType = "publish"
+	EventRollback     EventType = "rollback"
+	EventCheck        EventType = "check"
+	EventConnected    EventType = "connected"
+	EventDisconnected EventType = "disconnected"
+	// EventDropped is sent after events are dropped as the watch channel is
+	// full, the watcher should recheck all apps
+	EventDropped EventType = "dropped"
+)
+
+// Event is a notify event from dandelion server or a websocket connection
+// state change
+type Event struct {
+	Type  EventType
+	AppID string
+	// Config is the published or rolled back config
+	Config *app.AppConfig
+	// Err is the cause of disconnected event
+	Err  error
+	Time time.Time
+}
+
+// ConnState is the websocket connection state
+type ConnState int32
+
+// connection states
+const (
+	StateDisconnected ConnState = iota
+	StateConnecting
+	StateConnected
+	StateClosed
+	// StatePolling is long polling as the websocket upgrade is refused
+	StatePolling
+)
+
+var connStateNames = []string{"disconnected", "connecting", "connected", "closed", "polling"}
+
+// String returns the name of connection state
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateNames) {
+		return "unknown"
+	}
+	return connStateNames[s]
+}
+
+// WithReconnectBackoff sets the backoff of websocket reconnections, the wait
+// duration doubles from min to max for each failed attempt, with jitter
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(c *DandelionClient) {
+		c.reconnect = RetryPolicy{Backoff: min, MaxBackoff: max}
+	}
+}
+
+var (
+	jitterRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandLock sync.Mutex
+)
+
+// jitter returns a random duration in [d/2, d]
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	jitterRandLock.Lock()
+	defer jitterRandLock.Unlock()
+	return d/2 + time.Duration(jitterRand.Int63n(int64(d/2)+1))
+}
+
+func (c *DandelionClient) setState(s ConnState) {
+	atomic.StoreInt32(&c.state, int32(s))
+}
+
+// State returns current websocket connection state
+func (c *DandelionClient) State() ConnState {
+	return ConnState(atomic.LoadInt32(&c.state))
+}
+
+type watcher struct {
+	appID string
+	ch    chan Event
+}
+
+// Watch watches notify events of app, or all apps if appID is empty, and
+// websocket connection state changes. The channel is closed when ctx is done
+// or the client is closed, events are dropped if the channel is full and
+// followed by an EventDropped event.
+func (c *DandelionClient) Watch(ctx context.Context, appID string) (<-chan Event, error) {
+	if c.dial == nil {
+		return nil, ErrWebSocketDisabled
+	}
+	if c.closed() {
+		return nil, ErrClientClosed
+	}
+
+	// the last slot is reserved for the dropped event
+	w := &watcher{appID: appID, ch: make(chan Event, watchBufferSize+1)}
+	c.watchLock.Lock()
+	c.watchers[w] = struct{}{}
+	c.watchLock.Unlock()
+	c.wakePoll()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.closeCh:
+		}
+		c.watchLock.Lock()
+		delete(c.watchers, w)
+		close(w.ch)
+		c.watchLock.Unlock()
+	}()
+	return w.ch, nil
+}
+
+// broadcast sends event to matched watchers
+func (c *DandelionClient) broadcast(e Event) {
+	e.Time = time.Now()
+	c.watchLock.Lock()
+	defer c.watchLock.Unlock()
+	for w := range c.watchers {
+		if w.appID != "" && e.AppID != "" && e.AppID != w.appID {
+			continue
+		}
+		if len(w.ch) < watchBufferSize {
+			w.ch <- e
+			continue
+		}
+		clientLogger.Errorf("watch channel of %q is full, %s event dropped", w.appID, e.Type)
+		select {
+		case w.ch <- Event{Type: EventDropped, Time: e.Time}:
+		default:
+			// dropped event is pending
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case e, ok := <-ch:
+		require.True(t, ok, "watch channel closed")
+		return e
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "watch event timeout")
+	}
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	upgrader := websocket.Upgrader{}
+	conns := make(chan *websocket.Conn, 4)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	_, err := NewDandelionClient("http://127.0.0.1:1", false)
+	assert.Error(err)
+
+	c, err := NewDandelionClient(s.URL, true)
+	require.NoError(err)
+	_, err = c.Watch(context.Background(), "")
+	assert.Equal(ErrWebSocketDisabled, err)
+
+	c, err = NewDandelionClient(s.URL, false, WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	require.NoError(err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	all, err := c.Watch(ctx, "")
+	require.NoError(err)
+	s1, err := c.Watch(context.Background(), "s1")
+	require.NoError(err)
+
+	// connected before watching
+	conn := <-conns
+	assert.Equal(StateConnected, c.State())
+	assert.True(c.Connected())
+
+	require.NoError(conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"publish","app_id":"s2","config":{"id":2}}`)))
+	require.NoError(conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"publish","app_id":"s1","config":{"id":1,"commit_id":"abc"}}`)))
+	e := nextEvent(t, all)
+	assert.Equal(EventPublish, e.Type)
+	assert.Equal("s2", e.AppID)
+	e = nextEvent(t, s1)
+	assert.Equal(EventPublish, e.Type)
+	assert.Equal("s1", e.AppID)
+	assert.Equal("abc", e.Config.CommitID)
+	assert.Equal("s1", nextEvent(t, all).AppID)
+
+	// reconnect
+	conn.Close()
+	e = nextEvent(t, s1)
+	assert.Equal(EventDisconnected, e.Type)
+	assert.Error(e.Err)
+	conn = <-conns
+	assert.Equal(EventConnected, nextEvent(t, s1).Type)
+	assert.Equal(EventDisconnected, nextEvent(t, all).Type)
+	assert.Equal(EventConnected, nextEvent(t, all).Type)
+	require.NoError(conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"check","app_id":"s1"}`)))
+	assert.Equal(EventCheck, nextEvent(t, s1).Type)
+
+	// cancel watch
+	cancel()
+	for range all {
+	}
+
+	require.NoError(c.Close())
+	assert.Equal(StateClosed, c.State())
+	for range s1 {
+	}
+	_, err = c.Watch(context.Background(), "")
+	assert.Equal(ErrClientClosed, err)
+}
+
+func TestWatchDropped(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewDandelionClient(s.URL, false)
+	require.NoError(err)
+	defer c.Close()
+	ch, err := c.Watch(context.Background(), "s1")
+	require.NoError(err)
+
+	for i := 0; i < watchBufferSize+10; i++ {
+		c.broadcast(Event{Type: EventPublish, AppID: "s1"})
+	}
+	for i := 0; i < watchBufferSize; i++ {
+		assert.Equal(EventPublish, nextEvent(t, ch).Type)
+	}
+	// dropped only once until received
+	assert.Equal(EventDropped, nextEvent(t, ch).Type)
+	assert.Empty(ch)
+
+	c.broadcast(Event{Type: EventCheck, AppID: "s1"})
+	assert.Equal(EventCheck, nextEvent(t, ch).Type)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// AppManifestConfig is the applied files of a managed app config
+type AppManifestConfig struct {
+	Path     string            `json:"path"`
+	Status   string            `json:"status"`
+	ConfigID int64             `json:"config_id"`
+	CommitID string            `json:"commit_id"`
+	Files    map[string]string `json:"files"`
+}
+
+// AppManifest is the applied files of an app, revision increases after each
+// successful sync
+type AppManifest struct {
+	AppID    string              `json:"app_id"`
+	Revision uint64              `json:"revision"`
+	Configs  []AppManifestConfig `json:"configs"`
+}
+
+// syncWatcher notifies waiters after apps synced
+type syncWatcher struct {
+	mu        sync.Mutex
+	revisions map[string]uint64
+	waiters   map[string]chan struct{}
+}
+
+var appWatcher = newSyncWatcher()
+
+func newSyncWatcher() *syncWatcher {
+	return &syncWatcher{
+		revisions: make(map[string]uint64),
+		waiters:   make(map[string]chan struct{}),
+	}
+}
+
+// Revision returns the current revision of app
+func (w *syncWatcher) Revision(appID string) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.revisions[appID]
+}
+
+// Notify increases the revision of app and wakes up all waiters
+func (w *syncWatcher) Notify(appID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.revisions[appID]++
+	if ch, ok := w.waiters[appID]; ok {
+		close(ch)
+		delete(w.waiters, appID)
+	}
+}
+
+// Wait returns a channel which is closed once the revision of app is greater
+// than the specified revision. Revisions restart from 0 after the seed
+// restarted, so a revision greater than the current one is treated as a reset
+// and the channel is closed immediately.
+func (w *syncWatcher) Wait(appID string, revision uint64) <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.revisions[appID] != revision {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	ch, ok := w.waiters[appID]
+	if !ok {
+		ch = make(chan struct{})
+		w.waiters[appID] = ch
+	}
+	return ch
+}
+
+// GetAppManifest returns the applied files of app, it returns false if app
+// is not managed
+func GetAppManifest(appID string) (*AppManifest, bool) {
+	// get revision first, so that a sync during collecting is always noticed
+	// by the next wait
+	m := &AppManifest{AppID: appID, Revision: appWatcher.Revision(appID)}
+	for _, st := range GetAppStatuses(appID) {
+		m.Configs = append(m.Configs, AppManifestConfig{
+			Path:     st.Path,
+			Status:   st.Status,
+			ConfigID: st.ConfigID,
+			CommitID: st.CommitID,
+			Files:    st.Files,
+		})
+	}
+	return m, len(m.Configs) > 0
+}
+
+// LookupAppFile finds the local path of applied file of app, only files
+// synced from repository are available
+func LookupAppFile(appID, file string) (string, bool) {
+	file = strings.TrimPrefix(file, "/")
+	for _, c := range GetConfigs() {
+		if c.AppID != appID {
+			continue
+		}
+		st := getAppState(c.ID).Status()
+		if _, ok := st.Files[file]; !ok {
+			continue
+		}
+		if filePath, ok := c.LocalPath(file); ok {
+			return filePath, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+func TestSyncWatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newSyncWatcher()
+	ch := w.Wait("a", w.Revision("a"))
+	select {
+	case <-ch:
+		assert.Fail("woken before synced")
+	default:
+	}
+
+	w.Notify("b")
+	select {
+	case <-ch:
+		assert.Fail("woken by other app")
+	default:
+	}
+
+	w.Notify("a")
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		assert.Fail("not woken after synced")
+	}
+	assert.Equal(uint64(1), w.Revision("a"))
+
+	// synced after the known revision
+	select {
+	case <-w.Wait("a", 0):
+	default:
+		assert.Fail("not woken for stale revision")
+	}
+
+	// revision from before restarted
+	select {
+	case <-w.Wait("a", 5):
+	default:
+		assert.Fail("not woken for reset revision")
+	}
+}
+
+func TestAppFileHandlers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "a.conf"), []byte("a=1\n"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "local.conf"), []byte("secret\n"), 0644))
+
+	configs := Conf.Configs
+	Conf.Configs = []config.SectionConfig{{ID: 1001, AppID: "test", Path: dir}}
+	defer func() {
+		Conf.Configs = configs
+		removeAppState(1001)
+	}()
+	getAppState(1001).setResult(&app.AppConfig{ID: 1, CommitID: "abc"},
+		map[string]string{"a.conf": "d5e29449b9e66d5b4bb0d6ce48fbbcb1"}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/apps/:app_id/manifest", appManifestHandler)
+	r.GET("/apps/:app_id/files/*path", appFileHandler)
+	r.GET("/apps/:app_id/watch", appWatchHandler)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apps/test/manifest", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"commit_id":"abc"`)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apps/test/files/a.conf", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("a=1\n", rec.Body.String())
+	etag := rec.Header().Get("ETag")
+	assert.Equal(`"d5e29449b9e66d5b4bb0d6ce48fbbcb1"`, etag)
+
+	req := httptest.NewRequest(http.MethodGet, "/apps/test/files/a.conf", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(http.StatusNotModified, rec.Code)
+
+	// responds immediately for revision from before restarted
+	revision := appWatcher.Revision("test")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/apps/test/watch?revision=%d&timeout=5s", revision+5), nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), fmt.Sprintf(`"revision":%d`, revision))
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/apps/test/watch?revision=%d&timeout=10ms", revision), nil))
+	assert.Equal(http.StatusNotModified, rec.Code)
+
+	// only synced files are served
+	for _, p := range []string{"/apps/test/files/local.conf", "/apps/test/files/../local.conf", "/apps/other/files/a.conf", "/apps/other/manifest"} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		assert.Equal(http.StatusNotFound, rec.Code, p)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// ErrCheckTimeout is returned when an app check exceeds the check timeout
+var ErrCheckTimeout = errors.New("check timeout")
+
+// checkAppConfigContext checks an app config, it is replaced in tests
+var checkAppConfigContext = CheckAppConfigContext
+
+// CheckResult is the check result of an app config
+type CheckResult struct {
+	AppID    string
+	Path     string
+	Required bool
+	Duration time.Duration
+	Err      error
+}
+
+// CheckReport is the aggregated check results of app configs
+type CheckReport []CheckResult
+
+// Failed returns the failed results
+func (r CheckReport) Failed() []CheckResult {
+	var failed []CheckResult
+	for _, res := range r {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err returns an error if any required app failed
+func (r CheckReport) Err() error {
+	var msgs []string
+	for _, res := range r.Failed() {
+		if res.Required {
+			msgs = append(msgs, fmt.Sprintf("%s (%s): %v", res.AppID, res.Path, res.Err))
+		}
+	}
+	if len(msgs) <= 0 {
+		return nil
+	}
+	return fmt.Errorf("%d required apps failed: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Log logs the summary and failures of report
+func (r CheckReport) Log() {
+	failed := r.Failed()
+	for _, res := range failed {
+		if res.Required {
+			logger.Errorf("[%s] required app check failed after %v: %v", res.AppID, res.Duration, res.Err)
+		} else {
+			logger.Errorf("[%s] check failed after %v: %v", res.AppID, res.Duration, res.Err)
+		}
+	}
+	logger.Infof("checked %d apps: %d succeeded, %d failed", len(r), len(r)-len(failed), len(failed))
+}
+
+// checkAppConfigWithTimeout checks app config in isolation, a panic is
+// recovered as an error. The check is canceled after timeout, and its result
+// is reported as ErrCheckTimeout.
+func checkAppConfigWithTimeout(appConfig config.SectionConfig, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("check panic: %v", r)
+			}
+		}()
+		done <- checkAppConfigContext(ctx, &appConfig)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrCheckTimeout
+	}
+}
+
+// CheckConfigs checks app configs concurrently by a bounded worker pool
+func CheckConfigs(configs []config.SectionConfig) CheckReport {
+	check := GetCheckConfig()
+	workers := check.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(configs) {
+		workers = len(configs)
+	}
+
+	report := make(CheckReport, len(configs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := configs[i]
+				start := time.Now()
+				err := checkAppConfigWithTimeout(c, check.Timeout)
+				report[i] = CheckResult{
+					AppID:    c.AppID,
+					Path:     c.Path,
+					Required: c.Required,
+					Duration: time.Since(start),
+					Err:      err,
+				}
+			}
+		}()
+	}
+	for i := range configs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return report
+}
+
+// CheckCurrentConfigs checks all app configs, it returns an error only if a
+// required app failed
+func CheckCurrentConfigs() (CheckReport, error) {
+	report := CheckConfigs(GetConfigs())
+	report.Log()
+	return report, report.Err()
+}
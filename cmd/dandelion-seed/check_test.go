@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+// setCheckAppConfig replaces the check function, returns the function to
+// restore
+func setCheckAppConfig(f func(ctx context.Context, appConfig *config.SectionConfig) error) func() {
+	prev := checkAppConfigContext
+	checkAppConfigContext = f
+	return func() {
+		checkAppConfigContext = prev
+	}
+}
+
+func TestCheckConfigsWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	defer setCheckAppConfig(func(ctx context.Context, appConfig *config.SectionConfig) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})()
+	defer setConfigs(config.SectionCheck{Workers: 2})()
+
+	configs := make([]config.SectionConfig, 6)
+	for i := range configs {
+		configs[i] = config.SectionConfig{ID: i, AppID: fmt.Sprintf("app%d", i)}
+	}
+	report := CheckConfigs(configs)
+	assert.Len(report, len(configs))
+	assert.Empty(report.Failed())
+	assert.Equal(2, maxRunning)
+	for i, res := range report {
+		assert.Equal(configs[i].AppID, res.AppID)
+	}
+}
+
+func TestCheckConfigsIsolation(t *testing.T) {
+	assert := assert.New(t)
+
+	errFailed := errors.New("failed")
+	defer setCheckAppConfig(func(ctx context.Context, appConfig *config.SectionConfig) error {
+		switch appConfig.AppID {
+		case "failed":
+			return errFailed
+		case "panic":
+			panic("boom")
+		}
+		return nil
+	})()
+	defer setConfigs(config.SectionCheck{Workers: 1})()
+
+	report := CheckConfigs([]config.SectionConfig{
+		{ID: 1, AppID: "panic"},
+		{ID: 2, AppID: "ok1"},
+		{ID: 3, AppID: "failed", Required: true},
+		{ID: 4, AppID: "ok2"},
+	})
+	require.Len(t, report, 4)
+	assert.EqualError(report[0].Err, "check panic: boom")
+	assert.NoError(report[1].Err)
+	assert.Equal(errFailed, report[2].Err)
+	assert.NoError(report[3].Err)
+	assert.Len(report.Failed(), 2)
+	// only required apps fail
+	assert.EqualError(report.Err(), "1 required apps failed: failed (): failed")
+}
+
+func TestCheckConfigsTimeout(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, map[string]string{"a.conf": "a=1\n"})
+	defer s.Close()
+	canceled := make(chan struct{})
+	s.mu.Lock()
+	s.onMatch = func(r *http.Request) {
+		if r.URL.Path == client.APIPrefix+"/match/slow" {
+			<-r.Context().Done()
+			close(canceled)
+		}
+	}
+	s.mu.Unlock()
+	defer setConfigs(config.SectionCheck{Workers: 2, Timeout: 100 * time.Millisecond})()
+
+	configs := []config.SectionConfig{
+		{ID: 120, AppID: "slow", Path: filepath.Join(dir, "slow")},
+		{ID: 121, AppID: "fast", Path: filepath.Join(dir, "fast")},
+	}
+	defer removeAppState(configs[0].ID)
+	defer removeAppState(configs[1].ID)
+	report := CheckConfigs(configs)
+	assert.Equal(ErrCheckTimeout, report[0].Err)
+	assert.NoError(report[1].Err)
+	assert.Equal("a=1\n", readFile(t, filepath.Join(dir, "fast/a.conf")))
+
+	// the request of timed out check is canceled
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request is not canceled")
+	}
+	// waits for the canceled check to finish
+	st := getAppState(configs[0].ID)
+	st.mu.Lock()
+	st.mu.Unlock()
+	assert.Contains(st.Status().LastError, context.DeadlineExceeded.Error())
+	assert.NoFileExists(filepath.Join(dir, "slow/a.conf"))
+}
+
+func TestCheckConfigsTimeoutHook(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, map[string]string{"a.conf": "a=1\n"})
+	defer s.Close()
+	defer setConfigs(config.SectionCheck{Workers: 1, Timeout: 100 * time.Millisecond})()
+
+	configs := []config.SectionConfig{
+		{ID: 122, AppID: "slow", Path: filepath.Join(dir, "slow"), Hooks: config.SectionHooks{
+			PreSync: config.SectionHook{Command: "sleep 2", Timeout: 5 * time.Second},
+		}},
+	}
+	defer removeAppState(configs[0].ID)
+	report := CheckConfigs(configs)
+	assert.Equal(ErrCheckTimeout, report[0].Err)
+
+	// the pre_sync hook is killed with the check, and files are not written
+	st := getAppState(configs[0].ID)
+	st.mu.Lock()
+	st.mu.Unlock()
+	assert.Contains(st.Status().LastError, ErrSyncVetoed.Error())
+	assert.NoFileExists(filepath.Join(dir, "slow/a.conf"))
+}
@@ -2,20 +2,15 @@ package main
 
 import (
 	"archive/zip"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"os/user"
 	"path"
 	"regexp"
-	"strconv"
-	"strings"
-
-	shellwords "github.com/mattn/go-shellwords"
 
 	"github.com/tengattack/dandelion/app"
 	"github.com/tengattack/dandelion/client"
@@ -37,16 +32,23 @@ func init() {
 	metadataRegexp = regexp.MustCompile(`"?(version|host|instance_id)"?\s*[=:]\s*"?(\S+?)["\s$]`)
 }
 
-// ReadMetadataFromFile read metadata to client config from file
-func ReadMetadataFromFile(appConfig *config.SectionConfig) (*app.ClientConfig, error) {
-	hostname, _ := os.Hostname()
+// defaultHost returns the host from environments or hostname
+func defaultHost() string {
 	host := os.Getenv("NODE_NAME")
 	if host == "" {
 		host = os.Getenv("HOST")
 		if host == "" {
-			host = hostname
+			host, _ = os.Hostname()
 		}
 	}
+	return host
+}
+
+// ReadMetadataFromFile read metadata to client config from meta files and
+// metadata sources
+func ReadMetadataFromFile(appConfig *config.SectionConfig) (*app.ClientConfig, error) {
+	hostname, _ := os.Hostname()
+	host := defaultHost()
 	instanceID := os.Getenv("INSTANCE_ID")
 	if instanceID == "" {
 		instanceID = hostname
@@ -81,186 +83,285 @@ func ReadMetadataFromFile(appConfig *config.SectionConfig) (*app.ClientConfig, e
 			}
 		}
 	}
+	err := ReadMetadataSources(appConfig, &cfg)
+	if err != nil {
+		return nil, err
+	}
 	logger.Debugf("[%s] client config: %v", appConfig.AppID, cfg)
 	return &cfg, nil
 }
 
-func syncExpectedFile(appID string, except io.Reader, fi os.FileInfo, actualFileName, actualFile string) error {
-	f, err := os.Open(actualFile)
+// md5File calculates md5sum of local file
+func md5File(filePath string) (string, error) {
+	f, err := os.Open(filePath)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-		// write new directly
-		f, err := os.Create(actualFile)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(f, except)
-		if err != nil {
-			f.Close()
-			return err
-		}
-		f.Close()
-		err = os.Chtimes(actualFile, fi.ModTime(), fi.ModTime())
-		if err != nil {
-			logger.Warnf("[%s] chtimes %s error: %v", appID, actualFileName, err)
-		}
-		return nil
+		return "", err
 	}
-
+	defer f.Close()
 	h := md5.New()
 	_, err = io.Copy(h, f)
-	f.Close()
 	if err != nil {
-		return err
+		return "", err
 	}
-	actualMD5 := hex.EncodeToString(h.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	// compare md5
-	e, err := ioutil.ReadAll(except)
+// md5ZipFile calculates md5sum of file in archive
+func md5ZipFile(zf *zip.File) (string, error) {
+	fr, err := zf.Open()
 	if err != nil {
-		return err
+		return "", err
 	}
-	h = md5.New()
-	_, err = h.Write(e)
+	defer fr.Close()
+	h := md5.New()
+	_, err = io.Copy(h, fr)
 	if err != nil {
-		return err
+		return "", err
 	}
-	exceptMD5 := hex.EncodeToString(h.Sum(nil))
-
-	if actualMD5 != exceptMD5 {
-		logger.Debugf("[%s] file %s md5 mismatch: \"%s\" != \"%s\"", appID, actualFileName, actualMD5, exceptMD5)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-		f, err := os.Create(actualFile)
-		if err != nil {
-			return err
-		}
-		_, err = f.Write(e)
-		if err != nil {
-			f.Close()
-			return err
+// expectedFileChanged checks whether the local file differs from the archived one
+func expectedFileChanged(appID string, zf *zip.File, actualFileName, actualFile string) (bool, error) {
+	actualMD5, err := md5File(actualFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
 		}
+		return false, err
+	}
+	expectedMD5, err := md5ZipFile(zf)
+	if err != nil {
+		return false, err
+	}
+	if actualMD5 != expectedMD5 {
+		logger.Debugf("[%s] file %s md5 mismatch: \"%s\" != \"%s\"", appID, actualFileName, actualMD5, expectedMD5)
+		return true, nil
+	}
+	return false, nil
+}
+
+func writeExpectedFile(appID string, zf *zip.File, actualFileName, actualFile string) error {
+	fr, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	f, err := os.Create(actualFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, fr)
+	if err != nil {
 		f.Close()
-		err = os.Chtimes(actualFile, fi.ModTime(), fi.ModTime())
-		if err != nil {
-			logger.Warnf("[%s] chtimes %s error: %v", appID, actualFileName, err)
-		}
-		return nil
+		return err
 	}
+	f.Close()
 
+	fi := zf.FileInfo()
+	err = os.Chtimes(actualFile, fi.ModTime(), fi.ModTime())
+	if err != nil {
+		logger.Warnf("[%s] chtimes %s error: %v", appID, actualFileName, err)
+	}
 	return nil
 }
 
+// localPath returns the local path of managed repository file
+func localPath(appConfig *config.SectionConfig, file string) string {
+	p, _ := appConfig.LocalPath(file)
+	return p
+}
+
 // ResyncConfigFiles sync config files
-func ResyncConfigFiles(appConfig *config.SectionConfig, c *app.AppConfig, files []string) error {
+func ResyncConfigFiles(ctx context.Context, appConfig *config.SectionConfig, c *app.AppConfig, files []string) error {
 	logger.Infof("[%s] resyncing config files", c.AppID)
-	var uid, gid int
-	var mode os.FileMode
-	if appConfig.Chown != "" {
-		parts := strings.Split(appConfig.Chown, ":")
-		u, err := user.Lookup(parts[0])
-		if err != nil {
-			logger.Errorf("[%s] failed to lookup user '%s': %v", c.AppID, parts[0], err)
-			return err
-		}
-		uid, _ = strconv.Atoi(u.Uid)
-		if len(parts) > 1 {
-			g, err := user.LookupGroup(parts[1])
-			if err != nil {
-				logger.Errorf("[%s] failed to lookup group '%s': %v", c.AppID, parts[1], err)
-				return err
-			}
-			gid, _ = strconv.Atoi(g.Gid)
-		} else {
-
-			gid, _ = strconv.Atoi(u.Gid)
-		}
-	}
-	if appConfig.Chmod != "" {
-		modeVal, _ := strconv.ParseInt(appConfig.Chmod, 8, 32)
-		mode = os.FileMode(modeVal)
+	rules, err := newPermissionRules(appConfig)
+	if err != nil {
+		logger.Errorf("[%s] failed to init permission rules: %v", c.AppID, err)
+		return err
 	}
-	z, err := Client.GetZipArchive(c.AppID, c.CommitID)
+	z, err := Client.OpenZipArchiveContext(ctx, c.AppID, c.CommitID)
 	if err != nil {
 		return err
 	}
+	defer z.Close()
+	zipFiles := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		zipFiles[f.Name] = f
+	}
+
+	var changedFiles []string
+	changed := make(map[string]bool, len(files))
 	for _, fileName := range files {
-		var zf *zip.File
-		for _, f := range z.File {
-			if f.Name == fileName {
-				zf = f
-				break
-			}
-		}
-		if zf == nil {
+		zf, ok := zipFiles[fileName]
+		if !ok {
 			return ErrFileNotFoundInArchive
 		}
-		filePath := path.Join(appConfig.Path, fileName)
-		err = os.MkdirAll(path.Dir(filePath), os.ModePerm)
-		if err != nil && !os.IsExist(err) {
+		changed[fileName], err = expectedFileChanged(c.AppID, zf, fileName, localPath(appConfig, fileName))
+		if err != nil {
 			return err
 		}
-		fr, err := zf.Open()
+		if changed[fileName] {
+			changedFiles = append(changedFiles, fileName)
+		}
+	}
+
+	// files are not touched once canceled
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	// hooks and reload are skipped if no files need to be rewritten, the
+	// permissions are still applied
+	unchanged := len(changedFiles) <= 0
+	env := hookEnv(appConfig, c, changedFiles)
+	if !unchanged {
+		err = RunHook(ctx, appConfig, HookPreSync, env)
 		if err != nil {
 			return err
 		}
-		err = syncExpectedFile(c.AppID, fr, zf.FileInfo(), fileName, filePath)
-		fr.Close()
+	}
+
+	for _, fileName := range files {
+		// stop writing once the check is canceled, e.g. by pre_sync hook
+		// outliving the check timeout
+		err = ctx.Err()
 		if err != nil {
 			return err
 		}
-		if uid != 0 {
-			err := os.Chown(filePath, uid, gid)
+		root, rel, _ := appConfig.Locate(fileName)
+		filePath := path.Join(root, rel)
+		perm := rules.Match(fileName)
+		dirMode := perm.dirMode
+		if dirMode == 0 {
+			dirMode = os.ModePerm
+		}
+		err = os.MkdirAll(path.Dir(filePath), dirMode)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+		if perm.dirMode != 0 || perm.chown() {
+			for _, dir := range parentDirs(root, rel) {
+				err = setFilePermission(dir, perm.uid, perm.gid, perm.dirMode)
+				if err != nil {
+					logger.Errorf("[%s] failed to set permission for directory '%s': %v", c.AppID, dir, err)
+					return err
+				}
+			}
+		}
+		zf := zipFiles[fileName]
+		if changed[fileName] {
+			err = writeExpectedFile(c.AppID, zf, fileName, filePath)
 			if err != nil {
-				logger.Errorf("[%s] failed to change ownership for file '%s': %v", c.AppID, filePath, err)
 				return err
 			}
 		}
-		if mode != 0 {
-			err := os.Chmod(filePath, mode)
+		mode := perm.mode
+		if mode == 0 && zf.Mode()&0111 != 0 {
+			// keep executable bits in archive
+			fi, err := os.Stat(filePath)
 			if err != nil {
-				logger.Errorf("[%s] failed to change permission for file '%s': %v", c.AppID, filePath, err)
 				return err
 			}
+			if fi.Mode().Perm()&0111 != zf.Mode()&0111 {
+				mode = fi.Mode().Perm() | zf.Mode()&0111
+			}
 		}
-	}
-	if appConfig.ExecReload != "" {
-		parts, err := shellwords.Parse(appConfig.ExecReload)
+		err = setFilePermission(filePath, perm.uid, perm.gid, mode)
 		if err != nil {
-			logger.Errorf("[%s] parse reload command error: %v", c.AppID, err)
+			logger.Errorf("[%s] failed to set permission for file '%s': %v", c.AppID, filePath, err)
 			return err
 		}
-		out, err := exec.Command(parts[0], parts[1:]...).Output()
-		if len(out) > 0 {
-			logger.Infof("[%s] exec reload:\n%s", c.AppID, string(out))
-		} else {
-			logger.Infof("[%s] exec reload", c.AppID)
-		}
+	}
+	if unchanged {
+		logger.Infof("[%s] config files are unchanged, skip reloading", c.AppID)
+		return nil
+	}
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	out, err := ReloadApp(ctx, appConfig)
+	getAppState(appConfig.ID).setSynced(out)
+	if err != nil {
+		return err
+	}
+	return RunHook(ctx, appConfig, HookPostSync, env)
+}
+
+// setFilePermission changes ownership and permission of file, uid or gid is
+// -1 if unchanged, mode is 0 if unchanged. uid 0 is a valid owner, files
+// can be chowned to root explicitly.
+func setFilePermission(filePath string, uid, gid int, mode os.FileMode) error {
+	if uid >= 0 || gid >= 0 {
+		err := os.Chown(filePath, uid, gid)
 		if err != nil {
-			logger.Errorf("[%s] exec reload error: %v", c.AppID, err)
 			return err
 		}
 	}
+	if mode != 0 {
+		return os.Chmod(filePath, mode)
+	}
 	return nil
 }
 
-func checkConfig(appConfig *config.SectionConfig, clientConfig *app.ClientConfig) (*app.AppConfig, error) {
-	Client.SetStatus(clientConfig, client.StatusChecking)
-	c, err := Client.Match(clientConfig)
+// hashFiles calculates md5sum of each local config file
+func hashFiles(appConfig *config.SectionConfig, files []string) (map[string]string, error) {
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		md5sum, err := md5File(localPath(appConfig, file))
+		if err != nil {
+			return nil, err
+		}
+		hashes[file] = md5sum
+	}
+	return hashes, nil
+}
+
+// lastSyncedFilesMatch compares local files with the hashes of last
+// successful sync of the same commit, it is a shortcut to avoid fetching the
+// archive, the files are compared with the archive if it does not match (e.g.
+// after restarted)
+func lastSyncedFilesMatch(appConfig *config.SectionConfig, c *app.AppConfig, files []string) (bool, error) {
+	st := getAppState(appConfig.ID).Status()
+	if st.LastError != "" || st.CommitID != c.CommitID || len(st.Files) != len(files) {
+		return false, nil
+	}
+	for _, file := range files {
+		expected, ok := st.Files[file]
+		if !ok {
+			return false, nil
+		}
+		actual, err := md5File(localPath(appConfig, file))
+		if err != nil {
+			return false, err
+		}
+		if actual != expected {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func checkConfig(ctx context.Context, appConfig *config.SectionConfig, clientConfig *app.ClientConfig) (*app.AppConfig, map[string]string, error) {
+	setAppStatus(appConfig, clientConfig, client.StatusChecking)
+	c, err := Client.MatchContext(ctx, clientConfig)
 	if err != nil {
 		logger.Errorf("[%s] match error: %v", appConfig.AppID, err)
-		return nil, err
+		return nil, nil, err
 	}
-	files, err := Client.ListFiles(c.AppID, c.CommitID)
+	files, err := Client.ListFilesContext(ctx, c.AppID, c.CommitID)
 	if err != nil {
 		logger.Errorf("[%s] list files error: %v", c.AppID, err)
-		return c, err
+		return c, nil, err
 	}
+	managed := appConfig.ManagedFiles(files)
 	dirty := false
 	h := md5.New()
-	for _, file := range files {
-		filePath := path.Join(appConfig.Path, file)
+	for _, file := range managed {
+		filePath := localPath(appConfig, file)
 		s, err := os.Stat(filePath)
 		if os.IsNotExist(err) {
 			dirty = true
@@ -268,51 +369,93 @@ func checkConfig(appConfig *config.SectionConfig, clientConfig *app.ClientConfig
 			break
 		}
 		if err != nil {
-			return c, err
+			return c, nil, err
 		}
 		if s.IsDir() {
-			return c, ErrFileIsOccupiedByDir
+			return c, nil, ErrFileIsOccupiedByDir
 		}
 		f, err := os.Open(filePath)
 		if err != nil {
-			return c, err
+			return c, nil, err
 		}
 		defer f.Close()
 		io.Copy(h, f)
 	}
 	if !dirty {
-		md5sum := hex.EncodeToString(h.Sum(nil))
-		if md5sum != c.MD5Sum {
-			dirty = true
-			logger.Infof("[%s] config file md5sum mismatch: \"%s\" != \"%s\"", c.AppID, md5sum, c.MD5Sum)
+		if len(managed) == len(files) {
+			md5sum := hex.EncodeToString(h.Sum(nil))
+			if md5sum != c.MD5Sum {
+				dirty = true
+				logger.Infof("[%s] config file md5sum mismatch: \"%s\" != \"%s\"", c.AppID, md5sum, c.MD5Sum)
+			}
+		} else {
+			// md5sum of partial files is unknown, compare with last sync
+			match, err := lastSyncedFilesMatch(appConfig, c, managed)
+			if err != nil {
+				return c, nil, err
+			}
+			if !match {
+				dirty = true
+				logger.Infof("[%s] config file md5sum mismatch with last sync, comparing with archive", c.AppID)
+			}
 		}
 	}
 	if dirty {
-		Client.SetStatus(clientConfig, client.StatusSyncing, map[string]interface{}{
+		setAppStatus(appConfig, clientConfig, client.StatusSyncing, map[string]interface{}{
 			"config_id": c.ID,
 			"commit_id": c.CommitID,
 		})
 		// Sync config
-		err = ResyncConfigFiles(appConfig, c, files)
+		err = ResyncConfigFiles(ctx, appConfig, c, managed)
 		if err != nil {
 			logger.Errorf("[%s] resync config files error: %v", c.AppID, err)
-			return c, err
+			return c, nil, err
 		}
 	}
-	return c, nil
+	hashes, err := hashFiles(appConfig, managed)
+	if err != nil {
+		return c, nil, err
+	}
+	return c, hashes, nil
+}
+
+// setAppStatus sets both local and remote app status
+func setAppStatus(appConfig *config.SectionConfig, clientConfig *app.ClientConfig, status client.InstanceStatus, v ...interface{}) {
+	getAppState(appConfig.ID).setStatus(appConfig, status)
+	Client.SetStatus(clientConfig, status, v...)
 }
 
 // CheckAppConfig check single app's config
 func CheckAppConfig(appConfig *config.SectionConfig) error {
+	return CheckAppConfigContext(context.Background(), appConfig)
+}
+
+// CheckAppConfigContext is like CheckAppConfig but with context, the
+// requests to dandelion server are canceled with context
+func CheckAppConfigContext(ctx context.Context, appConfig *config.SectionConfig) error {
+	s := getAppState(appConfig.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	logger.Debugf("[%s] checking", appConfig.AppID)
 	clientConfig, err := ReadMetadataFromFile(appConfig)
 	if err != nil {
 		logger.Errorf("[%s] read metadata error: %v", appConfig.AppID, err)
+		s.setResult(nil, nil, err)
 		return err
 	}
+	s.setClientConfig(clientConfig)
 
 	var v map[string]interface{}
-	c, err := checkConfig(appConfig, clientConfig)
+	syncs := s.Syncs()
+	c, hashes, err := checkConfig(ctx, appConfig, clientConfig)
+	observeCheck(appConfig, c, err)
+	if err != nil {
+		env := append(hookEnv(appConfig, c, nil), "DANDELION_ERROR="+err.Error())
+		// on_error hook reports the failure even if the check is canceled
+		_ = RunHook(context.Background(), appConfig, HookOnError, env)
+	}
+	s.setResult(c, hashes, err)
 	if c != nil {
 		v = map[string]interface{}{
 			"config_id": c.ID,
@@ -321,19 +464,11 @@ func CheckAppConfig(appConfig *config.SectionConfig) error {
 	}
 	if err != nil {
 		Client.SetStatus(clientConfig, client.StatusError, v)
-	} else {
-		Client.SetStatus(clientConfig, client.StatusSuccess, v)
+		return err
 	}
-	return nil
-}
-
-// CheckCurrentConfigs check configs
-func CheckCurrentConfigs() error {
-	for _, config := range Conf.Configs {
-		err := CheckAppConfig(&config)
-		if err != nil {
-			return err
-		}
+	Client.SetStatus(clientConfig, client.StatusSuccess, v)
+	if s.Syncs() != syncs {
+		appWatcher.Notify(appConfig.AppID)
 	}
 	return nil
 }
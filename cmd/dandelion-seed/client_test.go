@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+// testServer is a fake dandelion server serving the latest commit of files
+// for any app, the global Client is replaced until it is closed
+type testServer struct {
+	*httptest.Server
+	client *client.DandelionClient
+	prev   *client.DandelionClient
+
+	mu       sync.Mutex
+	files    map[string]string
+	commitID string
+	commits  int
+	// onMatch is called before serving match requests
+	onMatch func(r *http.Request)
+}
+
+func newTestServer(t *testing.T, files map[string]string) *testServer {
+	s := &testServer{}
+	s.setFiles(files)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	c, err := client.NewDandelionClient(s.URL, true, client.WithTimeout(5*time.Second))
+	require.NoError(t, err)
+	s.client = c
+	s.prev = Client
+	Client = c
+	return s
+}
+
+// setFiles commits the new files
+func (s *testServer) setFiles(files map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = files
+	s.commits++
+	s.commitID = strings.Repeat(string(rune('0'+s.commits%10)), 40)
+}
+
+func (s *testServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	files, commitID, onMatch := s.files, s.commitID, s.onMatch
+	s.mu.Unlock()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, client.APIPrefix+"/"), "/")
+	var info interface{}
+	switch {
+	case len(parts) == 2 && parts[0] == "match":
+		if onMatch != nil {
+			onMatch(r)
+		}
+		h := md5.New()
+		for _, name := range names {
+			h.Write([]byte(files[name]))
+		}
+		info = map[string]interface{}{
+			"app_id": parts[1],
+			"config": app.AppConfig{ID: 1, AppID: parts[1], CommitID: commitID, MD5Sum: hex.EncodeToString(h.Sum(nil))},
+		}
+	case len(parts) == 4 && parts[0] == "list" && parts[2] == "tree" && parts[3] == commitID:
+		info = map[string]interface{}{"app_id": parts[1], "commit_id": commitID, "files": names}
+	case len(parts) == 3 && parts[0] == "archive" && parts[2] == commitID+".zip":
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			f, _ := zw.Create(name)
+			f.Write([]byte(files[name]))
+		}
+		zw.Close()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":404,"info":"not found"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "info": info})
+}
+
+// Close closes the server and restores the global Client
+func (s *testServer) Close() {
+	Client = s.prev
+	s.client.Close()
+	s.Server.Close()
+}
+
+func TestCheckMappedConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, map[string]string{"nginx/a.conf": "a=1\n", "nginx/b.conf": "b=1\n", "app/c.yml": "c: 1\n"})
+	defer s.Close()
+
+	appConfig := &config.SectionConfig{
+		ID:       110,
+		AppID:    "mapped",
+		Path:     dir,
+		Mappings: []config.SectionMapping{{Source: "nginx", Path: filepath.Join(dir, "nginx")}},
+	}
+	defer removeAppState(appConfig.ID)
+	require.NoError(CheckAppConfig(appConfig))
+	assert.Equal(1, getAppState(appConfig.ID).Syncs())
+	assert.Equal("a=1\n", readFile(t, filepath.Join(dir, "nginx/a.conf")))
+	assert.NoFileExists(filepath.Join(dir, "app/c.yml"))
+
+	// not synced again on restart
+	removeAppState(appConfig.ID)
+	require.NoError(CheckAppConfig(appConfig))
+	assert.Equal(0, getAppState(appConfig.ID).Syncs())
+	assert.Equal(client.StatusSuccess.String(), getAppState(appConfig.ID).Status().Status)
+
+	// synced if changed meanwhile
+	removeAppState(appConfig.ID)
+	writeFile(t, filepath.Join(dir, "nginx/b.conf"), "b=2\n")
+	require.NoError(CheckAppConfig(appConfig))
+	assert.Equal(1, getAppState(appConfig.ID).Syncs())
+	assert.Equal("b=1\n", readFile(t, filepath.Join(dir, "nginx/b.conf")))
+
+	// synced on new commit
+	s.setFiles(map[string]string{"nginx/a.conf": "a=2\n", "nginx/b.conf": "b=1\n"})
+	require.NoError(CheckAppConfig(appConfig))
+	assert.Equal(2, getAppState(appConfig.ID).Syncs())
+	assert.Equal("a=2\n", readFile(t, filepath.Join(dir, "nginx/a.conf")))
+}
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strconv"
+	"time"
 
+	"github.com/gobwas/glob"
 	"github.com/tengattack/tgo/log"
 
 	"gopkg.in/yaml.v2"
@@ -15,6 +21,8 @@ type Config struct {
 	Log       log.Config       `yaml:"log"`
 	Dandelion SectionDandelion `yaml:"dandelion"`
 	Kafka     SectionKafka     `yaml:"kafka"`
+	Check     SectionCheck     `yaml:"check"`
+	Inventory SectionInventory `yaml:"inventory"`
 	Configs   []SectionConfig  `yaml:"configs"`
 }
 
@@ -47,7 +55,28 @@ type SectionLogAgent struct {
 
 // SectionDandelion is sub section of config.
 type SectionDandelion struct {
-	URL string `yaml:"url"`
+	URL     URLList       `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+}
+
+// URLList is a list of urls, a single url is accepted in yaml
+type URLList []string
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (l *URLList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var u string
+	if err := unmarshal(&u); err == nil {
+		*l = URLList{u}
+		return nil
+	}
+	var urls []string
+	err := unmarshal(&urls)
+	if err != nil {
+		return err
+	}
+	*l = urls
+	return nil
 }
 
 // SectionKafka is sub section of config.
@@ -58,17 +87,132 @@ type SectionKafka struct {
 	Servers []string `yaml:"servers"`
 }
 
+// SectionCheck is sub section of config.
+type SectionCheck struct {
+	Jitter   time.Duration `yaml:"jitter"`
+	Watch    bool          `yaml:"watch"`
+	Interval time.Duration `yaml:"interval"`
+	Workers  int           `yaml:"workers"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// SectionInventory is sub section of config.
+type SectionInventory struct {
+	Host   string            `yaml:"host"`
+	Labels map[string]string `yaml:"labels"`
+}
+
 // SectionConfig is sub section of config.
 type SectionConfig struct {
-	ID         int
-	AppID      string   `yaml:"app_id"`
-	Path       string   `yaml:"path"`
-	Chown      string   `yaml:"chown"`
-	Chmod      string   `yaml:"chmod"`
-	MetaFiles  []string `yaml:"meta_files"`
-	ExecReload string   `yaml:"exec_reload"`
+	ID          int
+	AppID       string              `yaml:"app_id"`
+	Path        string              `yaml:"path"`
+	Required    bool                `yaml:"required"`
+	Chown       string              `yaml:"chown"`
+	Chmod       string              `yaml:"chmod"`
+	MetaFiles   []string            `yaml:"meta_files"`
+	Metadata    SectionMetadata     `yaml:"metadata"`
+	Permissions []SectionPermission `yaml:"permissions"`
+	Mappings    []SectionMapping    `yaml:"mappings"`
+	DriftPolicy string              `yaml:"drift_policy"`
+	ExecReload  string              `yaml:"exec_reload"`
+	Reload      SectionReload       `yaml:"reload"`
+	Hooks       SectionHooks        `yaml:"hooks"`
 }
 
+// SectionMetadata is sub section of SectionConfig.
+type SectionMetadata struct {
+	Required []string                `yaml:"required"`
+	Sources  []SectionMetadataSource `yaml:"sources"`
+}
+
+// SectionMetadataSource is sub section of SectionMetadata.
+type SectionMetadataSource struct {
+	Field   string        `yaml:"field"`
+	Type    string        `yaml:"type"`
+	File    string        `yaml:"file"`
+	Key     string        `yaml:"key"`
+	Command string        `yaml:"command"`
+	Value   string        `yaml:"value"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// SectionPermission is sub section of SectionConfig.
+type SectionPermission struct {
+	Match   string `yaml:"match"`
+	Owner   string `yaml:"owner"`
+	Group   string `yaml:"group"`
+	Mode    string `yaml:"mode"`
+	DirMode string `yaml:"dir_mode"`
+}
+
+// SectionReload is sub section of SectionConfig.
+type SectionReload struct {
+	Type        string        `yaml:"type"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Command     string        `yaml:"command"`
+	Signal      string        `yaml:"signal"`
+	PIDFile     string        `yaml:"pid_file"`
+	ProcessName string        `yaml:"process_name"`
+	URL         string        `yaml:"url"`
+	Method      string        `yaml:"method"`
+	Unit        string        `yaml:"unit"`
+	Action      string        `yaml:"action"`
+}
+
+// SectionHooks is sub section of SectionConfig.
+type SectionHooks struct {
+	PreSync  SectionHook `yaml:"pre_sync"`
+	PostSync SectionHook `yaml:"post_sync"`
+	OnError  SectionHook `yaml:"on_error"`
+}
+
+// SectionHook is sub section of SectionHooks.
+type SectionHook struct {
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+	User    string        `yaml:"user"`
+}
+
+// reload types
+const (
+	ReloadTypeNone    = "none"
+	ReloadTypeExec    = "exec"
+	ReloadTypeSignal  = "signal"
+	ReloadTypeHTTP    = "http"
+	ReloadTypeSystemd = "systemd"
+)
+
+// metadata fields
+const (
+	MetadataFieldHost       = "host"
+	MetadataFieldInstanceID = "instance_id"
+	MetadataFieldVersion    = "version"
+)
+
+// metadata source types
+const (
+	MetadataSourceJSON    = "json"
+	MetadataSourceYAML    = "yaml"
+	MetadataSourceDotenv  = "dotenv"
+	MetadataSourceEnv     = "env"
+	MetadataSourceCommand = "command"
+	MetadataSourceStatic  = "static"
+)
+
+// drift policies
+const (
+	DriftPolicyRestore = "restore"
+	DriftPolicyAlert   = "alert"
+)
+
+// default timeouts
+const (
+	DefaultReloadTimeout = 30 * time.Second
+	DefaultHookTimeout   = 30 * time.Second
+	DefaultMetaTimeout   = 10 * time.Second
+)
+
 // BuildDefaultConf is default config setting.
 func BuildDefaultConf() Config {
 	var conf Config
@@ -88,13 +232,22 @@ func BuildDefaultConf() Config {
 	conf.Log.Agent.Enabled = false
 
 	// Dandelion
-	conf.Dandelion.URL = "http://127.0.0.1:9012"
+	conf.Dandelion.URL = URLList{"http://127.0.0.1:9012"}
+	conf.Dandelion.Timeout = time.Minute
+	conf.Dandelion.Retries = 0
 
 	// Kafka
 	conf.Kafka.Enabled = false
 	conf.Kafka.Topic = ""
 	conf.Kafka.GroupID = ""
 
+	// Check
+	conf.Check.Jitter = 0
+	conf.Check.Watch = false
+	conf.Check.Interval = 0
+	conf.Check.Workers = 4
+	conf.Check.Timeout = 5 * time.Minute
+
 	return conf
 }
 
@@ -114,6 +267,10 @@ func LoadConfig(confPath string) (Config, error) {
 		return conf, err
 	}
 
+	if len(conf.Dandelion.URL) <= 0 {
+		return conf, errors.New("dandelion url is required")
+	}
+
 	if conf.Kafka.GroupID == "" {
 		instanceID := os.Getenv("INSTANCE_ID")
 		if instanceID == "" {
@@ -122,10 +279,238 @@ func LoadConfig(confPath string) (Config, error) {
 		conf.Kafka.GroupID = instanceID
 	}
 
+	if conf.Check.Workers <= 0 {
+		conf.Check.Workers = 1
+	}
+
 	// mark id
+	keys := make(map[string]bool, len(conf.Configs))
 	for i := range conf.Configs {
 		conf.Configs[i].ID = i
+		if keys[conf.Configs[i].Key()] {
+			return conf, fmt.Errorf("config %s: duplicated path %s", conf.Configs[i].AppID, conf.Configs[i].Path)
+		}
+		keys[conf.Configs[i].Key()] = true
+		err = initReload(&conf.Configs[i])
+		if err != nil {
+			return conf, err
+		}
+		initHooks(&conf.Configs[i])
+		err = initMetadata(&conf.Configs[i])
+		if err != nil {
+			return conf, err
+		}
+		err = initPermissions(&conf.Configs[i])
+		if err != nil {
+			return conf, err
+		}
+		err = initMappings(&conf.Configs[i])
+		if err != nil {
+			return conf, err
+		}
+		switch conf.Configs[i].DriftPolicy {
+		case "":
+			conf.Configs[i].DriftPolicy = DriftPolicyRestore
+		case DriftPolicyRestore, DriftPolicyAlert:
+		default:
+			return conf, fmt.Errorf("config %s: unknown drift policy %q", conf.Configs[i].AppID, conf.Configs[i].DriftPolicy)
+		}
 	}
 
 	return conf, nil
 }
+
+func initReload(c *SectionConfig) error {
+	r := &c.Reload
+	if r.Type == "" {
+		if c.ExecReload != "" {
+			// compatible with exec_reload
+			r.Type = ReloadTypeExec
+			r.Command = c.ExecReload
+		} else {
+			r.Type = ReloadTypeNone
+		}
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = DefaultReloadTimeout
+	}
+
+	switch r.Type {
+	case ReloadTypeNone:
+	case ReloadTypeExec:
+		if r.Command == "" {
+			return fmt.Errorf("config %s: reload command is required", c.AppID)
+		}
+	case ReloadTypeSignal:
+		if r.PIDFile == "" && r.ProcessName == "" {
+			return fmt.Errorf("config %s: reload pid_file or process_name is required", c.AppID)
+		}
+		if r.Signal == "" {
+			r.Signal = "HUP"
+		}
+	case ReloadTypeHTTP:
+		if r.URL == "" {
+			return fmt.Errorf("config %s: reload url is required", c.AppID)
+		}
+		if r.Method == "" {
+			r.Method = "POST"
+		}
+	case ReloadTypeSystemd:
+		if r.Unit == "" {
+			return fmt.Errorf("config %s: reload unit is required", c.AppID)
+		}
+		if r.Action == "" {
+			r.Action = "restart"
+		}
+	default:
+		return fmt.Errorf("config %s: unknown reload type %q", c.AppID, r.Type)
+	}
+	return nil
+}
+
+func initHooks(c *SectionConfig) {
+	for _, h := range []*SectionHook{&c.Hooks.PreSync, &c.Hooks.PostSync, &c.Hooks.OnError} {
+		if h.Timeout <= 0 {
+			h.Timeout = DefaultHookTimeout
+		}
+	}
+}
+
+func isMetadataField(field string) bool {
+	switch field {
+	case MetadataFieldHost, MetadataFieldInstanceID, MetadataFieldVersion:
+		return true
+	}
+	return false
+}
+
+func initMetadata(c *SectionConfig) error {
+	m := &c.Metadata
+	for _, field := range m.Required {
+		if !isMetadataField(field) {
+			return fmt.Errorf("config %s: unknown required metadata field %q", c.AppID, field)
+		}
+	}
+	for i := range m.Sources {
+		src := &m.Sources[i]
+		if !isMetadataField(src.Field) {
+			return fmt.Errorf("config %s: unknown metadata field %q", c.AppID, src.Field)
+		}
+		switch src.Type {
+		case MetadataSourceJSON, MetadataSourceYAML, MetadataSourceDotenv:
+			if src.File == "" || src.Key == "" {
+				return fmt.Errorf("config %s: metadata %s source file and key are required", c.AppID, src.Type)
+			}
+		case MetadataSourceEnv:
+			if src.Key == "" {
+				return fmt.Errorf("config %s: metadata env source key is required", c.AppID)
+			}
+		case MetadataSourceCommand:
+			if src.Command == "" {
+				return fmt.Errorf("config %s: metadata command source command is required", c.AppID)
+			}
+			if src.Timeout <= 0 {
+				src.Timeout = DefaultMetaTimeout
+			}
+		case MetadataSourceStatic:
+			if src.Value == "" {
+				return fmt.Errorf("config %s: metadata static source value is required", c.AppID)
+			}
+		default:
+			return fmt.Errorf("config %s: unknown metadata source type %q", c.AppID, src.Type)
+		}
+	}
+	return nil
+}
+
+func initPermissions(c *SectionConfig) error {
+	if c.Chmod != "" {
+		_, err := ParseFileMode(c.Chmod)
+		if err != nil {
+			return fmt.Errorf("config %s: invalid chmod %q", c.AppID, c.Chmod)
+		}
+	}
+	for _, p := range c.Permissions {
+		if p.Match == "" {
+			return fmt.Errorf("config %s: permission match is required", c.AppID)
+		}
+		_, err := glob.Compile(p.Match, '/')
+		if err != nil {
+			return fmt.Errorf("config %s: invalid permission match %q: %v", c.AppID, p.Match, err)
+		}
+		for _, mode := range []string{p.Mode, p.DirMode} {
+			if mode == "" {
+				continue
+			}
+			_, err = ParseFileMode(mode)
+			if err != nil {
+				return fmt.Errorf("config %s: invalid permission mode %q", c.AppID, mode)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseFileMode parses octal file mode like 0644, the setuid, setgid and
+// sticky bits like 04755 are mapped to the os.FileMode ones
+func ParseFileMode(mode string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if v&^07777 != 0 {
+		return 0, fmt.Errorf("invalid file mode %s", mode)
+	}
+	m := os.FileMode(v) & os.ModePerm
+	if v&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if v&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if v&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m, nil
+}
+
+// Key returns the unique key of the app config
+func (c *SectionConfig) Key() string {
+	return c.AppID + ":" + c.Path
+}
+
+// DiffConfigs compares the configs between old and new ones, it keeps the
+// ids of unremoved configs and assigns new ids to added configs from nextID.
+// nextID only increases, so that ids of removed configs are never reused.
+func DiffConfigs(oldConfigs, newConfigs []SectionConfig, nextID *int) (added, removed, changed []SectionConfig) {
+	oldMap := make(map[string]SectionConfig, len(oldConfigs))
+	for _, c := range oldConfigs {
+		oldMap[c.Key()] = c
+		if c.ID >= *nextID {
+			*nextID = c.ID + 1
+		}
+	}
+
+	newMap := make(map[string]struct{}, len(newConfigs))
+	for i := range newConfigs {
+		c := &newConfigs[i]
+		newMap[c.Key()] = struct{}{}
+		old, ok := oldMap[c.Key()]
+		if !ok {
+			c.ID = *nextID
+			*nextID++
+			added = append(added, *c)
+			continue
+		}
+		c.ID = old.ID
+		if !reflect.DeepEqual(old, *c) {
+			changed = append(changed, *c)
+		}
+	}
+	for _, c := range oldConfigs {
+		if _, ok := newMap[c.Key()]; !ok {
+			removed = append(removed, c)
+		}
+	}
+	return
+}
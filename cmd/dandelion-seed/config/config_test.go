@@ -0,0 +1,182 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oldConfigs := []SectionConfig{
+		{ID: 0, AppID: "a", Path: "/tmp/a"},
+		{ID: 1, AppID: "b", Path: "/tmp/b"},
+		{ID: 2, AppID: "c", Path: "/tmp/c", Chmod: "644"},
+	}
+	newConfigs := []SectionConfig{
+		{ID: 0, AppID: "c", Path: "/tmp/c", Chmod: "600"},
+		{ID: 1, AppID: "a", Path: "/tmp/a"},
+		{ID: 2, AppID: "d", Path: "/tmp/d"},
+	}
+
+	nextID := 0
+	added, removed, changed := DiffConfigs(oldConfigs, newConfigs, &nextID)
+	require.Len(added, 1)
+	require.Len(removed, 1)
+	require.Len(changed, 1)
+	assert.Equal("d", added[0].AppID)
+	assert.Equal(3, added[0].ID)
+	assert.Equal("b", removed[0].AppID)
+	assert.Equal(1, removed[0].ID)
+	assert.Equal("c", changed[0].AppID)
+	assert.Equal(2, changed[0].ID)
+
+	// ids of unremoved configs are kept
+	assert.Equal(2, newConfigs[0].ID)
+	assert.Equal(0, newConfigs[1].ID)
+	assert.Equal(3, newConfigs[2].ID)
+
+	added, removed, changed = DiffConfigs(newConfigs, newConfigs, &nextID)
+	assert.Empty(added)
+	assert.Empty(removed)
+	assert.Empty(changed)
+
+	// ids of removed configs are not reused
+	assert.Equal(4, nextID)
+	oldConfigs = newConfigs
+	newConfigs = []SectionConfig{
+		{AppID: "c", Path: "/tmp/c", Chmod: "600"},
+		{AppID: "a", Path: "/tmp/a"},
+	}
+	_, removed, _ = DiffConfigs(oldConfigs, newConfigs, &nextID)
+	require.Len(removed, 1)
+	assert.Equal(3, removed[0].ID)
+	oldConfigs = newConfigs
+	newConfigs = append(newConfigs, SectionConfig{AppID: "e", Path: "/tmp/e"})
+	added, _, _ = DiffConfigs(oldConfigs, newConfigs, &nextID)
+	require.Len(added, 1)
+	assert.Equal(4, added[0].ID)
+}
+
+func TestLoadConfigDuplicated(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	f, err := ioutil.TempFile("", "dandelion-seed")
+	require.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("dandelion:\n  url: http://a:9012\nconfigs:\n  - app_id: a\n    path: /tmp/a\n  - app_id: b\n    path: /tmp/a\n")
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	_, err = LoadConfig(f.Name())
+	assert.NoError(err)
+
+	require.NoError(ioutil.WriteFile(f.Name(), []byte("dandelion:\n  url: http://a:9012\nconfigs:\n  - app_id: a\n    path: /tmp/a\n  - app_id: a\n    path: /tmp/a\n"), 0644))
+	_, err = LoadConfig(f.Name())
+	assert.EqualError(err, "config a: duplicated path /tmp/a")
+}
+
+func TestParseFileMode(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		mode     string
+		expected os.FileMode
+		err      bool
+	}{
+		{"644", 0644, false},
+		{"0600", 0600, false},
+		{"4755", os.ModeSetuid | 0755, false},
+		{"02775", os.ModeSetgid | 0775, false},
+		{"1777", os.ModeSticky | 0777, false},
+		{"7777", os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0777, false},
+		{"17777", 0, true},
+		{"999", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		mode, err := ParseFileMode(tt.mode)
+		if tt.err {
+			assert.Error(err, tt.mode)
+			continue
+		}
+		assert.NoError(err, tt.mode)
+		assert.Equal(tt.expected, mode, tt.mode)
+	}
+
+	// legacy chmod is validated on load
+	assert.NoError(initPermissions(&SectionConfig{AppID: "test", Chmod: "664"}))
+	assert.Error(initPermissions(&SectionConfig{AppID: "test", Chmod: "999"}))
+	assert.Error(initPermissions(&SectionConfig{AppID: "test", Permissions: []SectionPermission{{Match: "*", Mode: "rw"}}}))
+}
+
+func TestMappings(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := SectionConfig{AppID: "a", Path: "/srv/a"}
+	p, ok := c.LocalPath("nginx/a.conf")
+	assert.True(ok)
+	assert.Equal("/srv/a/nginx/a.conf", p)
+
+	c.Mappings = []SectionMapping{
+		{Source: "/nginx/", Path: "/etc/nginx/conf.d", Exclude: []string{"*.bak"}},
+		{Source: "app", Path: "config", Include: []string{"*.yml", "**/*.json"}},
+		{Source: "", Path: "/srv/misc", Include: []string{"README.md"}},
+	}
+	require.NoError(initMappings(&c))
+	assert.Equal("nginx", c.Mappings[0].Source)
+
+	cases := []struct {
+		file  string
+		local string
+		ok    bool
+	}{
+		{"nginx/a.conf", "/etc/nginx/conf.d/a.conf", true},
+		{"nginx/sub/b.conf", "/etc/nginx/conf.d/sub/b.conf", true},
+		{"nginx/a.conf.bak", "", false},
+		{"nginxx/a.conf", "", false},
+		{"app/c.yml", "/srv/a/config/c.yml", true},
+		{"app/sub/d.json", "/srv/a/config/sub/d.json", true},
+		{"app/sub/e.yml", "", false},
+		{"README.md", "/srv/misc/README.md", true},
+		{"other.txt", "", false},
+	}
+	for _, tc := range cases {
+		p, ok := c.LocalPath(tc.file)
+		assert.Equal(tc.ok, ok, tc.file)
+		assert.Equal(tc.local, p, tc.file)
+	}
+
+	assert.Equal([]string{"nginx/a.conf", "app/c.yml"},
+		c.ManagedFiles([]string{"nginx/a.conf", "nginx/a.conf.bak", "app/c.yml", "other.txt"}))
+	roots, sources := c.LocalRoots()
+	assert.Equal([]string{"/etc/nginx/conf.d", "/srv/a/config", "/srv/misc"}, roots)
+	assert.Equal([]string{"nginx", "app", ""}, sources)
+
+	c.Mappings = []SectionMapping{{Source: "nginx"}}
+	assert.Error(initMappings(&c))
+	c.Mappings = []SectionMapping{{Source: "nginx", Path: "/etc/nginx", Include: []string{"[a"}}}
+	assert.Error(initMappings(&c))
+}
+
+func TestURLList(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var c struct {
+		Dandelion SectionDandelion `yaml:"dandelion"`
+	}
+	require.NoError(yaml.Unmarshal([]byte("dandelion:\n  url: http://a:9012\n"), &c))
+	assert.Equal(URLList{"http://a:9012"}, c.Dandelion.URL)
+	require.NoError(yaml.Unmarshal([]byte("dandelion:\n  url:\n    - http://a:9012\n    - http://b:9012\n"), &c))
+	assert.Equal(URLList{"http://a:9012", "http://b:9012"}, c.Dandelion.URL)
+	assert.Error(yaml.Unmarshal([]byte("dandelion:\n  url: {a: b}\n"), &c))
+}
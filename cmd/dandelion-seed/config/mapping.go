@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gobwas/glob"
+)
+
+// SectionMapping is sub section of SectionConfig.
+type SectionMapping struct {
+	Source  string   `yaml:"source"`
+	Path    string   `yaml:"path"`
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+var globCache sync.Map
+
+// compileGlob compiles and caches the glob of pattern
+func compileGlob(pattern string) (glob.Glob, error) {
+	if g, ok := globCache.Load(pattern); ok {
+		return g.(glob.Glob), nil
+	}
+	g, err := glob.Compile(pattern, '/')
+	if err != nil {
+		return nil, err
+	}
+	globCache.Store(pattern, g)
+	return g, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		g, err := compileGlob(pattern)
+		if err == nil && g.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func initMappings(c *SectionConfig) error {
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		m.Source = strings.Trim(path.Clean("/"+m.Source), "/")
+		if m.Path == "" {
+			return fmt.Errorf("config %s: mapping path is required", c.AppID)
+		}
+		for _, pattern := range append(append([]string{}, m.Include...), m.Exclude...) {
+			_, err := compileGlob(pattern)
+			if err != nil {
+				return fmt.Errorf("config %s: invalid mapping glob %q: %v", c.AppID, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// root returns the local directory of mapping, relative path is under the
+// app path
+func (m *SectionMapping) root(appPath string) string {
+	if path.IsAbs(m.Path) {
+		return path.Clean(m.Path)
+	}
+	return path.Join(appPath, m.Path)
+}
+
+// Match checks whether the file relative to source is included by the mapping
+func (m *SectionMapping) Match(rel string) bool {
+	if len(m.Include) > 0 && !matchAny(m.Include, rel) {
+		return false
+	}
+	return !matchAny(m.Exclude, rel)
+}
+
+// Locate finds the local directory and the relative path of repository file,
+// the first matched mapping wins. It returns false if the file is not
+// mapped.
+func (c *SectionConfig) Locate(file string) (root, rel string, ok bool) {
+	if len(c.Mappings) <= 0 {
+		return c.Path, file, true
+	}
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		rel = file
+		if m.Source != "" {
+			if !strings.HasPrefix(file, m.Source+"/") {
+				continue
+			}
+			rel = file[len(m.Source)+1:]
+		}
+		if m.Match(rel) {
+			return m.root(c.Path), rel, true
+		}
+	}
+	return "", "", false
+}
+
+// LocalPath returns the local path of repository file
+func (c *SectionConfig) LocalPath(file string) (string, bool) {
+	root, rel, ok := c.Locate(file)
+	if !ok {
+		return "", false
+	}
+	return path.Join(root, rel), true
+}
+
+// ManagedFiles filters the repository files which are mapped to local paths
+func (c *SectionConfig) ManagedFiles(files []string) []string {
+	if len(c.Mappings) <= 0 {
+		return files
+	}
+	managed := make([]string, 0, len(files))
+	for _, file := range files {
+		if _, _, ok := c.Locate(file); ok {
+			managed = append(managed, file)
+		}
+	}
+	return managed
+}
+
+// LocalRoots returns the local directories and their repository sources
+func (c *SectionConfig) LocalRoots() (roots []string, sources []string) {
+	if len(c.Mappings) <= 0 {
+		return []string{c.Path}, []string{""}
+	}
+	for i := range c.Mappings {
+		roots = append(roots, c.Mappings[i].root(c.Path))
+		sources = append(sources, c.Mappings[i].Source)
+	}
+	return roots, sources
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+var (
+	confLock sync.RWMutex
+	// fileConf is the config loaded from file, before any runtime changes
+	fileConf config.Config
+	// nextConfigID is the id of next added app config
+	nextConfigID int
+)
+
+// GetConfigs returns current app configs
+func GetConfigs() []config.SectionConfig {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	configs := make([]config.SectionConfig, len(Conf.Configs))
+	copy(configs, Conf.Configs)
+	return configs
+}
+
+// GetConfig returns current app config by id, ok is false if it is removed
+func GetConfig(id int) (appConfig config.SectionConfig, ok bool) {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	for _, c := range Conf.Configs {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return appConfig, false
+}
+
+// GetCheckConfig returns current check config
+func GetCheckConfig() config.SectionCheck {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return Conf.Check
+}
+
+// ReloadConfig reloads configs from file, starts managing added apps, stops
+// managing removed apps and checks changed apps immediately.
+// NOTICE: only the check and configs sections are reloaded, other sections
+// need restart.
+func ReloadConfig(confPath string) error {
+	conf, err := config.LoadConfig(confPath)
+	if err != nil {
+		logger.Errorf("reload config error: %v", err)
+		return err
+	}
+
+	confLock.Lock()
+	if !reflect.DeepEqual(conf.API, fileConf.API) ||
+		!reflect.DeepEqual(conf.Log, fileConf.Log) ||
+		!reflect.DeepEqual(conf.Dandelion, fileConf.Dandelion) ||
+		!reflect.DeepEqual(conf.Kafka, fileConf.Kafka) {
+		logger.Warnf("only check and configs sections will be reloaded, restart to apply other sections")
+	}
+	added, removed, changed := config.DiffConfigs(Conf.Configs, conf.Configs, &nextConfigID)
+	Conf.Check = conf.Check
+	Conf.Configs = conf.Configs
+	fileConf = conf
+	confLock.Unlock()
+
+	logger.Infof("config reloaded: %d added, %d removed, %d changed", len(added), len(removed), len(changed))
+
+	for i := range removed {
+		logger.Infof("[%s] stop managing %s", removed[i].AppID, removed[i].Path)
+		Client.RemoveStatus(removed[i].ID)
+		s := getAppState(removed[i].ID)
+		s.mu.Lock()
+		removeAppMetrics(&removed[i])
+		s.mu.Unlock()
+		removeAppState(removed[i].ID)
+	}
+	for i := range added {
+		logger.Infof("[%s] start managing %s", added[i].AppID, added[i].Path)
+	}
+	if len(added)+len(changed) > 0 {
+		CheckConfigs(append(added, changed...)).Log()
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// driftTick debounces file events and refreshes the watched directories
+const driftTick = time.Second
+
+// findDriftedFiles compares the managed files with the hashes of last
+// successful sync
+func findDriftedFiles(appConfig *config.SectionConfig, hashes map[string]string) ([]string, error) {
+	var drifted []string
+	for file, md5sum := range hashes {
+		actual, err := md5File(localPath(appConfig, file))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if actual != md5sum {
+			drifted = append(drifted, file)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted, nil
+}
+
+// detectDrift detects drifted files of app, returns true if they need to be
+// restored
+func detectDrift(appConfig *config.SectionConfig, s *appState) (bool, error) {
+	st := s.Status()
+	if st.ClientConfig == nil || st.Files == nil {
+		// never synced
+		return false, nil
+	}
+	if st.Status != client.StatusSuccess.String() && st.Status != client.StatusDrifted.String() {
+		// leave it to next check
+		return false, nil
+	}
+	drifted, err := findDriftedFiles(appConfig, st.Files)
+	if err != nil {
+		return false, err
+	}
+	if len(drifted) <= 0 {
+		if st.Status == client.StatusDrifted.String() {
+			logger.Infof("[%s] drifted files recovered", appConfig.AppID)
+			s.setDrift(nil)
+			Client.SetStatus(st.ClientConfig, client.StatusSuccess, map[string]interface{}{
+				"config_id": st.ConfigID,
+				"commit_id": st.CommitID,
+			})
+		}
+		return false, nil
+	}
+
+	if st.Status == client.StatusDrifted.String() && reflect.DeepEqual(st.DriftFiles, drifted) {
+		// already reported
+		return false, nil
+	}
+	logger.Warnf("[%s] drifted files: %v", appConfig.AppID, drifted)
+	if appConfig.DriftPolicy == config.DriftPolicyRestore {
+		return true, nil
+	}
+	s.setDrift(drifted)
+	Client.SetStatus(st.ClientConfig, client.StatusDrifted, map[string]interface{}{
+		"config_id":   st.ConfigID,
+		"commit_id":   st.CommitID,
+		"drift_files": drifted,
+	})
+	return false, nil
+}
+
+// DetectDrift re-hashes the managed files of app, drifted files are either
+// restored or reported by the drift policy
+func DetectDrift(appConfig *config.SectionConfig) error {
+	s := getAppState(appConfig.ID)
+	s.mu.Lock()
+	restore, err := detectDrift(appConfig, s)
+	s.mu.Unlock()
+	if err != nil {
+		logger.Errorf("[%s] detect drift error: %v", appConfig.AppID, err)
+		return err
+	}
+	if !restore {
+		return nil
+	}
+	logger.Infof("[%s] restoring drifted files", appConfig.AppID)
+	return CheckAppConfig(appConfig)
+}
+
+// watchedDirs collects the directories of managed files
+func watchedDirs() map[string][]int {
+	dirs := make(map[string][]int)
+	for _, c := range GetConfigs() {
+		st := getAppState(c.ID).Status()
+		seen := make(map[string]bool)
+		roots, _ := c.LocalRoots()
+		for _, root := range roots {
+			seen[filepath.Clean(root)] = true
+		}
+		for file := range st.Files {
+			seen[filepath.Dir(filepath.FromSlash(localPath(&c, file)))] = true
+		}
+		for dir := range seen {
+			dirs[dir] = append(dirs[dir], c.ID)
+		}
+	}
+	return dirs
+}
+
+// driftDetector marks apps dirty on file events or every check interval,
+// and hands the dirty apps to the detect worker on each tick, so that hashing
+// and restoring files never block the watcher loop
+type driftDetector struct {
+	w         *fsnotify.Watcher
+	events    chan fsnotify.Event
+	errs      chan error
+	dirs      map[string][]int
+	dirty     map[int]bool
+	detects   chan map[int]bool
+	lastCheck time.Time
+}
+
+func newDriftDetector() *driftDetector {
+	return &driftDetector{
+		dirs:      make(map[string][]int),
+		dirty:     make(map[int]bool),
+		detects:   make(chan map[int]bool, 1),
+		lastCheck: time.Now(),
+	}
+}
+
+func (d *driftDetector) close() {
+	if d.w != nil {
+		d.w.Close()
+		d.w, d.events, d.errs = nil, nil, nil
+		d.dirs = make(map[string][]int)
+	}
+}
+
+func (d *driftDetector) handleEvent(e fsnotify.Event) {
+	if e.Op == fsnotify.Chmod {
+		return
+	}
+	for _, id := range d.dirs[filepath.Dir(e.Name)] {
+		d.dirty[id] = true
+	}
+}
+
+func (d *driftDetector) tick() {
+	check := GetCheckConfig()
+	if check.Interval > 0 && time.Since(d.lastCheck) >= check.Interval {
+		d.lastCheck = time.Now()
+		for _, c := range GetConfigs() {
+			d.dirty[c.ID] = true
+		}
+	}
+	if len(d.dirty) > 0 {
+		select {
+		case d.detects <- d.dirty:
+			d.dirty = make(map[int]bool)
+		default:
+			// the worker is busy, keep them dirty until next tick
+		}
+	}
+
+	if !check.Watch {
+		d.close()
+		return
+	}
+	if d.w == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			logger.Errorf("create file watcher error: %v", err)
+			return
+		}
+		d.w, d.events, d.errs = w, w.Events, w.Errors
+	}
+	newDirs := watchedDirs()
+	for dir := range d.dirs {
+		if _, ok := newDirs[dir]; !ok {
+			_ = d.w.Remove(dir)
+		}
+	}
+	for dir := range newDirs {
+		if _, ok := d.dirs[dir]; !ok {
+			err := d.w.Add(dir)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					logger.Errorf("watch %s error: %v", dir, err)
+				}
+				// retry on next tick
+				delete(newDirs, dir)
+			}
+		}
+	}
+	d.dirs = newDirs
+}
+
+// detect detects drifted files of dirty apps
+func (d *driftDetector) detect(dirty map[int]bool) {
+	for _, c := range GetConfigs() {
+		if dirty[c.ID] {
+			_ = DetectDrift(&c)
+		}
+	}
+}
+
+// runDetects runs the detections handed over by tick one at a time
+func (d *driftDetector) runDetects() {
+	for dirty := range d.detects {
+		d.detect(dirty)
+	}
+}
+
+// RunDriftDetector detects drifted files by watching the managed files and
+// re-hashing them periodically
+func RunDriftDetector() {
+	d := newDriftDetector()
+	defer d.close()
+	go d.runDetects()
+
+	ticker := time.NewTicker(driftTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-d.events:
+			d.handleEvent(e)
+		case err := <-d.errs:
+			logger.Errorf("watch files error: %v", err)
+		case <-ticker.C:
+			d.tick()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+func readFile(t *testing.T, filePath string) string {
+	data, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func writeFile(t *testing.T, filePath, content string) {
+	require.NoError(t, ioutil.WriteFile(filePath, []byte(content), 0644))
+}
+
+// setConfigs replaces the current configs, returns the function to restore
+func setConfigs(check config.SectionCheck, configs ...config.SectionConfig) func() {
+	confLock.Lock()
+	defer confLock.Unlock()
+	prevCheck, prevConfigs := Conf.Check, Conf.Configs
+	Conf.Check, Conf.Configs = check, configs
+	return func() {
+		confLock.Lock()
+		defer confLock.Unlock()
+		Conf.Check, Conf.Configs = prevCheck, prevConfigs
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, map[string]string{"a.conf": "a=1\n", "b/c.conf": "c=1\n"})
+	defer s.Close()
+
+	// alert
+	appConfig := &config.SectionConfig{ID: 100, AppID: "drift", Path: filepath.Join(dir, "alert"), DriftPolicy: config.DriftPolicyAlert}
+	defer removeAppState(appConfig.ID)
+	require.NoError(CheckAppConfig(appConfig))
+	st := getAppState(appConfig.ID).Status()
+	require.Equal(client.StatusSuccess.String(), st.Status)
+	assert.Len(st.Files, 2)
+
+	require.NoError(DetectDrift(appConfig))
+	assert.Equal(client.StatusSuccess.String(), getAppState(appConfig.ID).Status().Status)
+
+	writeFile(t, filepath.Join(appConfig.Path, "a.conf"), "a=2\n")
+	require.NoError(os.Remove(filepath.Join(appConfig.Path, "b/c.conf")))
+	require.NoError(DetectDrift(appConfig))
+	st = getAppState(appConfig.ID).Status()
+	assert.Equal(client.StatusDrifted.String(), st.Status)
+	assert.Equal([]string{"a.conf", "b/c.conf"}, st.DriftFiles)
+	// drifted files are kept
+	assert.Equal("a=2\n", readFile(t, filepath.Join(appConfig.Path, "a.conf")))
+
+	writeFile(t, filepath.Join(appConfig.Path, "a.conf"), "a=1\n")
+	writeFile(t, filepath.Join(appConfig.Path, "b/c.conf"), "c=1\n")
+	require.NoError(DetectDrift(appConfig))
+	st = getAppState(appConfig.ID).Status()
+	assert.Equal(client.StatusSuccess.String(), st.Status)
+	assert.Empty(st.DriftFiles)
+
+	// restore
+	appConfig = &config.SectionConfig{ID: 101, AppID: "drift", Path: filepath.Join(dir, "restore"), DriftPolicy: config.DriftPolicyRestore}
+	defer removeAppState(appConfig.ID)
+	require.NoError(CheckAppConfig(appConfig))
+	syncs := getAppState(appConfig.ID).Syncs()
+
+	writeFile(t, filepath.Join(appConfig.Path, "a.conf"), "a=2\n")
+	require.NoError(DetectDrift(appConfig))
+	st = getAppState(appConfig.ID).Status()
+	assert.Equal(client.StatusSuccess.String(), st.Status)
+	assert.Empty(st.DriftFiles)
+	assert.Equal("a=1\n", readFile(t, filepath.Join(appConfig.Path, "a.conf")))
+	assert.Equal(syncs+1, getAppState(appConfig.ID).Syncs())
+}
+
+func TestDriftDetector(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, map[string]string{"a.conf": "a=1\n"})
+	defer s.Close()
+
+	appConfig := config.SectionConfig{ID: 102, AppID: "drift", Path: dir, DriftPolicy: config.DriftPolicyAlert}
+	defer removeAppState(appConfig.ID)
+	restore := setConfigs(config.SectionCheck{Interval: time.Hour}, appConfig)
+	defer restore()
+	require.NoError(CheckAppConfig(&appConfig))
+
+	d := newDriftDetector()
+	defer d.close()
+	status := func() string {
+		return getAppState(appConfig.ID).Status().Status
+	}
+
+	// detections are handed over to the worker
+	detect := func() {
+		select {
+		case dirty := <-d.detects:
+			d.detect(dirty)
+		default:
+		}
+	}
+
+	// re-hashed every interval
+	writeFile(t, filepath.Join(dir, "a.conf"), "a=2\n")
+	d.tick()
+	assert.Empty(d.detects)
+	assert.Equal(client.StatusSuccess.String(), status())
+	d.lastCheck = time.Now().Add(-time.Hour)
+	d.tick()
+	assert.Equal(client.StatusSuccess.String(), status())
+	detect()
+	assert.Equal(client.StatusDrifted.String(), status())
+
+	// kept dirty while the worker is busy
+	d.detects <- map[int]bool{}
+	d.lastCheck = time.Now().Add(-time.Hour)
+	d.tick()
+	assert.Len(d.dirty, 1)
+	detect()
+	d.tick()
+	assert.Empty(d.dirty)
+	detect()
+
+	// re-hashed on file events
+	restore()
+	restore = setConfigs(config.SectionCheck{Watch: true}, appConfig)
+	d.tick()
+	require.NotNil(d.w)
+	assert.Contains(d.dirs, filepath.Clean(dir))
+	writeFile(t, filepath.Join(dir, "a.conf"), "a=1\n")
+	d.handleEvent(fsnotify.Event{Name: filepath.Join(dir, "a.conf"), Op: fsnotify.Chmod})
+	d.tick()
+	detect()
+	assert.Equal(client.StatusDrifted.String(), status())
+	d.handleEvent(fsnotify.Event{Name: filepath.Join(dir, "a.conf"), Op: fsnotify.Write})
+	d.tick()
+	detect()
+	assert.Equal(client.StatusSuccess.String(), status())
+
+	// stops watching
+	restore()
+	restore = setConfigs(config.SectionCheck{}, appConfig)
+	d.tick()
+	assert.Nil(d.w)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// FileDiff is the pending change of a local config file
+type FileDiff struct {
+	app.FileDiff
+	LocalPath string `json:"local_path"`
+}
+
+// DryRunResult is the pending changes of an app config
+type DryRunResult struct {
+	AppID      string     `json:"app_id"`
+	Path       string     `json:"path"`
+	ConfigID   int64      `json:"config_id"`
+	CommitID   string     `json:"commit_id"`
+	Files      []FileDiff `json:"files"`
+	Reload     bool       `json:"reload"`
+	ReloadType string     `json:"reload_type"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// DryRunAppConfig finds the pending changes of app config without touching
+// any local files
+func DryRunAppConfig(appConfig *config.SectionConfig) (*DryRunResult, error) {
+	r := &DryRunResult{
+		AppID:      appConfig.AppID,
+		Path:       appConfig.Path,
+		Files:      []FileDiff{},
+		ReloadType: appConfig.Reload.Type,
+	}
+	clientConfig, err := ReadMetadataFromFile(appConfig)
+	if err != nil {
+		logger.Errorf("[%s] read metadata error: %v", appConfig.AppID, err)
+		return r, err
+	}
+	c, err := Client.Match(clientConfig)
+	if err != nil {
+		logger.Errorf("[%s] match error: %v", appConfig.AppID, err)
+		return r, err
+	}
+	r.ConfigID = c.ID
+	r.CommitID = c.CommitID
+
+	files, err := Client.ListFiles(c.AppID, c.CommitID)
+	if err != nil {
+		logger.Errorf("[%s] list files error: %v", c.AppID, err)
+		return r, err
+	}
+	files = appConfig.ManagedFiles(files)
+	z, err := Client.OpenZipArchive(c.AppID, c.CommitID)
+	if err != nil {
+		logger.Errorf("[%s] get zip archive error: %v", c.AppID, err)
+		return r, err
+	}
+	defer z.Close()
+	targets := make(map[string][]byte, len(files))
+	for _, f := range z.File {
+		fr, err := f.Open()
+		if err != nil {
+			return r, err
+		}
+		data, err := ioutil.ReadAll(fr)
+		fr.Close()
+		if err != nil {
+			return r, err
+		}
+		targets[f.Name] = data
+	}
+
+	for _, fileName := range files {
+		target, ok := targets[fileName]
+		if !ok {
+			return r, ErrFileNotFoundInArchive
+		}
+		action := app.FileActionModify
+		local, err := ioutil.ReadFile(localPath(appConfig, fileName))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return r, err
+			}
+			action = app.FileActionAdd
+		} else if bytes.Equal(local, target) {
+			continue
+		}
+		diff, err := app.UnifiedDiff(fileName, local, target, action)
+		if err != nil {
+			return r, err
+		}
+		r.Files = append(r.Files, FileDiff{
+			FileDiff:  app.FileDiff{File: fileName, Action: action, Diff: diff},
+			LocalPath: localPath(appConfig, fileName),
+		})
+	}
+
+	r.Reload = len(r.Files) > 0 && r.ReloadType != "" && r.ReloadType != config.ReloadTypeNone
+	return r, nil
+}
+
+// String formats the result as a human readable patch
+func (r *DryRunResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# [%s] %s: config %d, commit %s\n", r.AppID, r.Path, r.ConfigID, r.CommitID)
+	if r.Error != "" {
+		fmt.Fprintf(&b, "# error: %s\n", r.Error)
+		return b.String()
+	}
+	if len(r.Files) <= 0 {
+		b.WriteString("# no changes\n")
+		return b.String()
+	}
+	for _, f := range r.Files {
+		fmt.Fprintf(&b, "# %s %s (%s)\n", f.Action, f.File, f.LocalPath)
+	}
+	if r.Reload {
+		fmt.Fprintf(&b, "# %s reload would run\n", r.ReloadType)
+	} else {
+		b.WriteString("# no reload\n")
+	}
+	for _, f := range r.Files {
+		b.WriteString(f.Diff)
+	}
+	return b.String()
+}
+
+// DryRunConfigs finds the pending changes of app configs, all apps are
+// included if appID is empty
+func DryRunConfigs(appID string) []*DryRunResult {
+	results := []*DryRunResult{}
+	for _, config := range GetConfigs() {
+		if appID != "" && config.AppID != appID {
+			continue
+		}
+		r, err := DryRunAppConfig(&config)
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results
+}
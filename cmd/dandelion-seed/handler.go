@@ -1,17 +1,41 @@
 package main
 
 import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gobwas/glob"
 
 	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
 	"github.com/tengattack/tgo/logger"
 )
 
 const (
 	// ParamsError the http bad request for error params
 	ParamsError = "Params error"
+
+	// watchTimeout is the max duration of a long polling watch
+	watchTimeout = 5 * time.Minute
+	// watchKeepAlive is the keepalive interval of server-sent events
+	watchKeepAlive = 30 * time.Second
+)
+
+var (
+	jitterRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandLock sync.Mutex
 )
 
 func abortWithError(c *gin.Context, code int, message string) {
@@ -28,27 +52,120 @@ func succeed(c *gin.Context, message interface{}) {
 	})
 }
 
+// MatchNotifyMessage checks whether the published config in message matches
+// the client config, messages without config always match
+func MatchNotifyMessage(m *app.NotifyMessage, clientConfig *app.ClientConfig) bool {
+	if m.Config == nil {
+		return true
+	}
+	g, err := glob.Compile(m.Config.Host)
+	if err != nil {
+		logger.Warnf("config %d host glob compile failed: %v", m.Config.ID, err)
+		return true
+	}
+	if !g.Match(clientConfig.Host) {
+		return false
+	}
+	g, err = glob.Compile(m.Config.InstanceID)
+	if err != nil {
+		logger.Warnf("config %d instance_id glob compile failed: %v", m.Config.ID, err)
+		return true
+	}
+	return g.Match(clientConfig.InstanceID)
+}
+
+func jitterDuration(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	jitterRandLock.Lock()
+	defer jitterRandLock.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(jitter)))
+}
+
+func checkAppConfigLater(appConfig *config.SectionConfig, d time.Duration) {
+	s := getAppState(appConfig.ID)
+	if !s.setPending() {
+		logger.Debugf("[%s] check is already pending", appConfig.AppID)
+		return
+	}
+	logger.Debugf("[%s] checking in %v", appConfig.AppID, d)
+	id, key := appConfig.ID, appConfig.Key()
+	time.AfterFunc(d, func() {
+		s.clearPending()
+		// the config may be changed or removed by reloading meanwhile
+		appConfig, ok := GetConfig(id)
+		if !ok || appConfig.Key() != key {
+			logger.Debugf("skip check of removed config %s", key)
+			return
+		}
+		err := CheckAppConfig(&appConfig)
+		if err != nil {
+			logger.WithField("app_id", appConfig.AppID).Errorf("handle message error: %v", err)
+			// PASS
+		}
+	})
+}
+
 // HandleMessage handle dandelion messages
 func HandleMessage(m *app.NotifyMessage) {
 	switch m.Event {
-	case "check":
-		fallthrough
-	case "publish":
-		fallthrough
-	case "rollback":
-		for _, config := range Conf.Configs {
-			// TODO: check matching of host and instance_id
-			if config.AppID == m.AppID {
-				err := CheckAppConfig(&config)
+	case "check", "publish", "rollback":
+		jitter := GetCheckConfig().Jitter
+		for _, config := range GetConfigs() {
+			if config.AppID != m.AppID {
+				continue
+			}
+			config := config
+			if m.Event != "check" {
+				clientConfig, err := ReadMetadataFromFile(&config)
 				if err != nil {
-					logger.WithField("app_id", m.AppID).Errorf("handle message error: %v", err)
+					logger.WithField("app_id", m.AppID).Errorf("read metadata error: %v", err)
 					// PASS
+				} else if !MatchNotifyMessage(m, clientConfig) {
+					logger.Debugf("[%s] skip unmatched %s message", m.AppID, m.Event)
+					continue
 				}
 			}
+			checkAppConfigLater(&config, jitterDuration(jitter))
 		}
 	}
 }
 
+// wsDisconnected is whether the websocket is disconnected, it is only
+// accessed by the event loop
+var wsDisconnected bool
+
+// checkAllConfigsLater checks all app configs after jitter
+func checkAllConfigsLater() {
+	jitter := GetCheckConfig().Jitter
+	for _, config := range GetConfigs() {
+		config := config
+		checkAppConfigLater(&config, jitterDuration(jitter))
+	}
+}
+
+// HandleEvent handles watch events of dandelion client, all apps are checked
+// after reconnected or events dropped as messages may be missed
+func HandleEvent(e client.Event) {
+	switch e.Type {
+	case client.EventConnected:
+		if wsDisconnected {
+			wsDisconnected = false
+			checkAllConfigsLater()
+		}
+		sdNotify("STATUS=" + sdStatus())
+	case client.EventDisconnected:
+		wsDisconnected = true
+		sdNotify("STATUS=" + sdStatus())
+	case client.EventDropped:
+		logger.Warnf("watch events dropped, checking all apps")
+		checkAllConfigsLater()
+	default:
+		HandleMessage(&app.NotifyMessage{Event: string(e.Type), AppID: e.AppID, Config: e.Config})
+	}
+}
+
 func appHealthHandler(c *gin.Context) {
 	succeed(c, "success")
 }
@@ -62,7 +179,7 @@ func appCheckHandler(c *gin.Context) {
 	}
 
 	var errs []error
-	for _, config := range Conf.Configs {
+	for _, config := range GetConfigs() {
 		if config.AppID == appID {
 			errs = append(errs, CheckAppConfig(&config))
 		}
@@ -78,3 +195,162 @@ func appCheckHandler(c *gin.Context) {
 		"errors": errs,
 	})
 }
+
+// localOnly only allows requests from loopback addresses
+func localOnly(c *gin.Context) {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		abortWithError(c, http.StatusForbidden, "local access only")
+		return
+	}
+	c.Next()
+}
+
+func appStatusHandler(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	statuses := GetAppStatuses(appID)
+	if appID != "" && len(statuses) <= 0 {
+		abortWithError(c, http.StatusNotFound, "not found specified app_id")
+		return
+	}
+
+	succeed(c, gin.H{
+		"connected": Client.Connected(),
+		"inventory": GetInventory(),
+		"statuses":  statuses,
+	})
+}
+
+func appDryRunHandler(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	results := DryRunConfigs(appID)
+	if appID != "" && len(results) <= 0 {
+		abortWithError(c, http.StatusNotFound, "not found specified app_id")
+		return
+	}
+
+	succeed(c, gin.H{
+		"results": results,
+	})
+}
+
+func appManifestHandler(c *gin.Context) {
+	m, ok := GetAppManifest(c.Param("app_id"))
+	if !ok {
+		abortWithError(c, http.StatusNotFound, "not found specified app_id")
+		return
+	}
+
+	succeed(c, m)
+}
+
+func appFileHandler(c *gin.Context) {
+	filePath, ok := LookupAppFile(c.Param("app_id"), c.Param("path"))
+	if !ok {
+		abortWithError(c, http.StatusNotFound, "not found specified file")
+		return
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// etag from the actual content, as the local file may drift
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf("\"%x\"", h.Sum(nil)))
+	http.ServeContent(c.Writer, c.Request, fi.Name(), fi.ModTime(), f)
+}
+
+func appWatchHandler(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	m, ok := GetAppManifest(appID)
+	if !ok {
+		abortWithError(c, http.StatusNotFound, "not found specified app_id")
+		return
+	}
+	revision := m.Revision
+	s := c.Query("revision")
+	if s == "" {
+		// reconnected event source
+		s = c.GetHeader("Last-Event-ID")
+	}
+	if s != "" {
+		var err error
+		revision, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, ParamsError)
+			return
+		}
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		// server-sent events, an event is sent after each sync
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		ticker := time.NewTicker(watchKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-appWatcher.Wait(appID, revision):
+				m, _ = GetAppManifest(appID)
+				revision = m.Revision
+				data, _ := json.Marshal(m)
+				fmt.Fprintf(c.Writer, "id: %d\nevent: sync\ndata: %s\n\n", revision, data)
+			case <-ticker.C:
+				fmt.Fprint(c.Writer, ": keepalive\n\n")
+			case <-c.Request.Context().Done():
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+
+	// long polling, responds once synced after revision or timeout
+	timeout := watchTimeout
+	if s := c.Query("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			abortWithError(c, http.StatusBadRequest, ParamsError)
+			return
+		}
+		if d < timeout {
+			timeout = d
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-appWatcher.Wait(appID, revision):
+		m, _ = GetAppManifest(appID)
+		succeed(c, m)
+	case <-timer.C:
+		c.Status(http.StatusNotModified)
+	case <-c.Request.Context().Done():
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+func TestMatchNotifyMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	clientConfig := &app.ClientConfig{AppID: "test", Host: "web-1", InstanceID: "pod-a"}
+	cases := []struct {
+		config *app.AppConfig
+		match  bool
+	}{
+		{nil, true},
+		{&app.AppConfig{Host: "*", InstanceID: "*"}, true},
+		{&app.AppConfig{Host: "web-*", InstanceID: "pod-?"}, true},
+		{&app.AppConfig{Host: "web-1", InstanceID: "pod-a"}, true},
+		{&app.AppConfig{Host: "db-*", InstanceID: "*"}, false},
+		{&app.AppConfig{Host: "*", InstanceID: "pod-b"}, false},
+		{&app.AppConfig{Host: "web-{1,2}", InstanceID: "*"}, true},
+		// invalid globs always match
+		{&app.AppConfig{Host: "web-[", InstanceID: "pod-b"}, true},
+		{&app.AppConfig{Host: "*", InstanceID: "pod-["}, true},
+		{&app.AppConfig{Host: "db-*", InstanceID: "pod-["}, false},
+	}
+	for _, c := range cases {
+		m := &app.NotifyMessage{Event: "publish", AppID: "test", Config: c.config}
+		assert.Equal(c.match, MatchNotifyMessage(m, clientConfig), "%+v", c.config)
+	}
+}
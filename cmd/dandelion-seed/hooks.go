@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	shellwords "github.com/mattn/go-shellwords"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// hook names
+const (
+	HookPreSync  = "pre_sync"
+	HookPostSync = "post_sync"
+	HookOnError  = "on_error"
+)
+
+// ErrSyncVetoed is returned when pre_sync hook vetoes the sync
+var ErrSyncVetoed = errors.New("sync vetoed by pre_sync hook")
+
+// runCommand runs command with extra env and captures its combined output
+func runCommand(ctx context.Context, command, username string, env []string) (string, error) {
+	parts, err := shellwords.Parse(command)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) <= 0 {
+		return "", errors.New("empty command")
+	}
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if username != "" {
+		err = setCommandUser(cmd, username)
+		if err != nil {
+			return "", err
+		}
+	}
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if len(out) > maxReloadOutput {
+		out = out[:maxReloadOutput]
+	}
+	return string(out), err
+}
+
+// hookEnv builds the environment variables for hooks, DANDELION_CHANGED_FILES
+// is separated by newline as file names may contain spaces
+func hookEnv(appConfig *config.SectionConfig, c *app.AppConfig, changedFiles []string) []string {
+	st := getAppState(appConfig.ID).Status()
+	env := []string{
+		"DANDELION_APP_ID=" + appConfig.AppID,
+		"DANDELION_APP_PATH=" + appConfig.Path,
+		"DANDELION_PREVIOUS_CONFIG_ID=" + strconv.FormatInt(st.ConfigID, 10),
+		"DANDELION_PREVIOUS_COMMIT_ID=" + st.CommitID,
+		"DANDELION_CHANGED_FILES=" + strings.Join(changedFiles, "\n"),
+	}
+	if c != nil {
+		env = append(env,
+			"DANDELION_CONFIG_ID="+strconv.FormatInt(c.ID, 10),
+			"DANDELION_COMMIT_ID="+c.CommitID,
+		)
+	}
+	if st.ClientConfig != nil {
+		env = append(env,
+			"DANDELION_HOST="+st.ClientConfig.Host,
+			"DANDELION_INSTANCE_ID="+st.ClientConfig.InstanceID,
+			"DANDELION_VERSION="+st.ClientConfig.Version,
+		)
+	}
+	return env
+}
+
+func getHook(appConfig *config.SectionConfig, name string) *config.SectionHook {
+	switch name {
+	case HookPreSync:
+		return &appConfig.Hooks.PreSync
+	case HookPostSync:
+		return &appConfig.Hooks.PostSync
+	case HookOnError:
+		return &appConfig.Hooks.OnError
+	}
+	return nil
+}
+
+// RunHook runs the specified hook of app, an error returned by pre_sync hook
+// vetoes the sync. The hook is killed once ctx is done.
+func RunHook(ctx context.Context, appConfig *config.SectionConfig, name string, env []string) error {
+	hook := getHook(appConfig, name)
+	if hook == nil || hook.Command == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	out, err := runCommand(ctx, hook.Command, hook.User, append(env, "DANDELION_HOOK="+name))
+	getAppState(appConfig.ID).setHookOutput(name, out)
+	if len(out) > 0 {
+		logger.Infof("[%s] %s hook:\n%s", appConfig.AppID, name, out)
+	} else {
+		logger.Infof("[%s] %s hook", appConfig.AppID, name)
+	}
+	if err != nil {
+		logger.Errorf("[%s] %s hook error: %v", appConfig.AppID, name, err)
+		if name == HookPreSync {
+			return fmt.Errorf("%w: %v", ErrSyncVetoed, err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// setCommandUser is not supported for windows
+func setCommandUser(cmd *exec.Cmd, username string) error {
+	return errors.New("run as user is not supported for windows")
+}
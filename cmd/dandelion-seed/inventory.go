@@ -0,0 +1,38 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+)
+
+var startTime = time.Now()
+
+// GetInventory returns the inventory of seed host
+func GetInventory() *app.Inventory {
+	host := Conf.Inventory.Host
+	if host == "" {
+		host = defaultHost()
+	}
+	seen := make(map[string]bool)
+	apps := app.AppList{}
+	for _, c := range GetConfigs() {
+		if !seen[c.AppID] {
+			seen[c.AppID] = true
+			apps = append(apps, c.AppID)
+		}
+	}
+	sort.Strings(apps)
+	return &app.Inventory{
+		Host:    host,
+		Version: client.UserAgent,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Uptime:  int64(time.Since(startTime) / time.Second),
+		Labels:  app.Labels(Conf.Inventory.Labels),
+		Apps:    apps,
+	}
+}
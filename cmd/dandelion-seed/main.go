@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 
 	_ "go.uber.org/automaxprocs"
 
@@ -38,6 +40,7 @@ func main() {
 	}
 	configPath := flag.String("config", defaultConfigPath, "config file")
 	syncOnly := flag.Bool("sync-only", false, "sync config only")
+	dryRun := flag.Bool("dry-run", false, "show pending config changes without applying them")
 	showVerbose := flag.Bool("verbose", false, "show verbose debug log")
 	showHelp := flag.Bool("help", false, "show help message")
 	flag.Parse()
@@ -56,6 +59,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	fileConf = conf
 	if *showVerbose {
 		conf.Log.AccessLevel = "debug"
 		conf.Log.ErrorLevel = "debug"
@@ -68,14 +72,42 @@ func main() {
 	}
 	client.SetLogger(log.GetClientLogger())
 
-	Client, err = client.NewDandelionClient(Conf.Dandelion.URL, *syncOnly)
+	Client, err = client.NewDandelionClient(Conf.Dandelion.URL[0], *syncOnly || *dryRun,
+		client.WithEndpoints(Conf.Dandelion.URL[1:]...),
+		client.WithTimeout(Conf.Dandelion.Timeout),
+		client.WithRetry(client.RetryPolicy{
+			MaxAttempts: Conf.Dandelion.Retries + 1,
+			Backoff:     time.Second,
+			MaxBackoff:  30 * time.Second,
+		}))
 	if err != nil {
 		logger.Errorf("dandelion init error: %v", err)
 		panic(err)
 	}
 	defer Client.Close()
 
-	err = CheckCurrentConfigs()
+	if *dryRun {
+		failed := false
+		for _, r := range DryRunConfigs("") {
+			fmt.Print(r.String())
+			if r.Error != "" {
+				failed = true
+			}
+		}
+		if failed {
+			Client.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = Client.SetInventoryFunc(GetInventory)
+	if err != nil {
+		logger.Errorf("report inventory error: %v", err)
+		// PASS
+	}
+
+	report, err := CheckCurrentConfigs()
 	if err != nil {
 		logger.Errorf("check current configs error: %v", err)
 		panic(err)
@@ -83,18 +115,40 @@ func main() {
 
 	if *syncOnly {
 		// sync config only, exiting now
+		if len(report.Failed()) > 0 {
+			Client.Close()
+			os.Exit(1)
+		}
 		return
 	}
 
-	Client.SetNotifyMessageHandler(func(m *app.NotifyMessage) {
-		HandleMessage(m)
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := Client.Watch(ctx, "")
+	if err != nil {
+		logger.Errorf("watch events error: %v", err)
+		panic(err)
+	}
 
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
+	hupchan := make(chan os.Signal, 1)
+	signal.Notify(hupchan, syscall.SIGHUP)
+	go func() {
+		for range hupchan {
+			logger.Infof("received SIGHUP, reloading config")
+			sdNotify("RELOADING=1")
+			_ = ReloadConfig(*configPath)
+			sdNotify("READY=1\nSTATUS=" + sdStatus())
+		}
+	}()
+
 	go RunHTTPServer()
+	go RunDriftDetector()
 
+	var messages <-chan string
+	var sigC <-chan os.Signal = sigchan
 	if Conf.Kafka.Enabled {
 		m, err := mq.NewConsumer(Conf.Kafka.Servers, Conf.Kafka.Topic, Conf.Kafka.GroupID, sigchan)
 		if err != nil {
@@ -102,9 +156,31 @@ func main() {
 			panic(err)
 		}
 		defer m.Close()
+		messages = m.Messages()
+		// signals are handled by the consumer
+		sigC = nil
+	}
+
+	// initial configs are in place
+	sdNotify("READY=1\nSTATUS=" + sdStatus())
+	defer sdNotify("STOPPING=1")
 
-		for message := range m.Messages() {
+	var tick <-chan time.Time
+	if d := sdTickInterval(); d > 0 {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				// consumer stopped by signal
+				return
+			}
 			logger.Infof("received message: %s", message)
+			kafkaMessagesCounter.Inc()
 			var m app.NotifyMessage
 			err := json.Unmarshal([]byte(message), &m)
 			if err != nil {
@@ -112,8 +188,16 @@ func main() {
 				continue
 			}
 			HandleMessage(&m)
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			HandleEvent(e)
+		case <-tick:
+			sdTick()
+		case <-sigC:
+			return
 		}
-	} else {
-		<-sigchan
 	}
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	shellwords "github.com/mattn/go-shellwords"
+	"gopkg.in/yaml.v2"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// errors
+var (
+	ErrMetadataNotFound = errors.New("metadata not found")
+	ErrInvalidKeyPath   = errors.New("invalid key path")
+)
+
+// parseKeyPath parses key path like `$.a.b[0]` to map keys and slice indexes
+func parseKeyPath(keyPath string) ([]interface{}, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(keyPath, "$"), ".")
+	if p == "" {
+		return nil, fmt.Errorf("%v: %s", ErrInvalidKeyPath, keyPath)
+	}
+	var keys []interface{}
+	for _, part := range strings.Split(p, ".") {
+		if part == "" {
+			return nil, fmt.Errorf("%v: %s", ErrInvalidKeyPath, keyPath)
+		}
+		name := part
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			name = part[:i]
+		}
+		if name != "" {
+			keys = append(keys, name)
+		}
+		rest := part[len(name):]
+		for rest != "" {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("%v: %s", ErrInvalidKeyPath, keyPath)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%v: %s", ErrInvalidKeyPath, keyPath)
+			}
+			keys = append(keys, index)
+			rest = rest[end+1:]
+		}
+	}
+	return keys, nil
+}
+
+// lookupKeyPath looks up the value of key path in decoded json or yaml
+func lookupKeyPath(v interface{}, keyPath string) (interface{}, error) {
+	keys, err := parseKeyPath(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var ok bool
+		switch k := key.(type) {
+		case string:
+			switch m := v.(type) {
+			case map[string]interface{}:
+				v, ok = m[k]
+			case map[interface{}]interface{}:
+				v, ok = m[k]
+			}
+		case int:
+			if s, isSlice := v.([]interface{}); isSlice && k < len(s) {
+				v, ok = s[k], true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%v: %s", ErrMetadataNotFound, keyPath)
+		}
+	}
+	return v, nil
+}
+
+// metadataString converts scalar value to string
+func metadataString(v interface{}) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case bool, int, int64, uint64, float64, json.Number:
+		return fmt.Sprint(s), nil
+	}
+	return "", fmt.Errorf("metadata value is not a scalar: %T", v)
+}
+
+// parseDotenv parses KEY=VALUE lines of dotenv file
+func parseDotenv(data []byte) map[string]string {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		env[key] = value
+	}
+	return env
+}
+
+func readMetadataCommand(src *config.SectionMetadataSource) (string, error) {
+	parts, err := shellwords.Parse(src.Command)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) <= 0 {
+		return "", errors.New("empty command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), src.Timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, parts[0], parts[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readMetadataSource reads the value of metadata source, relative files are
+// under the app path
+func readMetadataSource(appPath string, src *config.SectionMetadataSource) (string, error) {
+	var data []byte
+	switch src.Type {
+	case config.MetadataSourceJSON, config.MetadataSourceYAML, config.MetadataSourceDotenv:
+		filePath := src.File
+		if !filepath.IsAbs(filePath) {
+			filePath = filepath.Join(appPath, filePath)
+		}
+		var err error
+		data, err = ioutil.ReadFile(filePath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var value string
+	switch src.Type {
+	case config.MetadataSourceJSON, config.MetadataSourceYAML:
+		var v interface{}
+		var err error
+		if src.Type == config.MetadataSourceJSON {
+			d := json.NewDecoder(bytes.NewReader(data))
+			d.UseNumber()
+			err = d.Decode(&v)
+		} else {
+			err = yaml.Unmarshal(data, &v)
+		}
+		if err != nil {
+			return "", fmt.Errorf("parse %s error: %v", src.File, err)
+		}
+		v, err = lookupKeyPath(v, src.Key)
+		if err != nil {
+			return "", err
+		}
+		value, err = metadataString(v)
+		if err != nil {
+			return "", err
+		}
+	case config.MetadataSourceDotenv:
+		value = parseDotenv(data)[src.Key]
+	case config.MetadataSourceEnv:
+		value = os.Getenv(src.Key)
+	case config.MetadataSourceCommand:
+		var err error
+		value, err = readMetadataCommand(src)
+		if err != nil {
+			return "", err
+		}
+	case config.MetadataSourceStatic:
+		value = src.Value
+	default:
+		return "", fmt.Errorf("unknown metadata source type %q", src.Type)
+	}
+	if value == "" {
+		return "", fmt.Errorf("%v: %s", ErrMetadataNotFound, src.Key)
+	}
+	return value, nil
+}
+
+func describeMetadataSource(src *config.SectionMetadataSource) string {
+	switch src.Type {
+	case config.MetadataSourceJSON, config.MetadataSourceYAML:
+		return fmt.Sprintf("%s %s:%s", src.Type, src.File, src.Key)
+	case config.MetadataSourceDotenv:
+		return fmt.Sprintf("dotenv %s:%s", src.File, src.Key)
+	case config.MetadataSourceEnv:
+		return "env " + src.Key
+	case config.MetadataSourceCommand:
+		return "command " + src.Command
+	}
+	return src.Type
+}
+
+// ReadMetadataSources reads metadata to client config from typed sources,
+// the first resolved source of each field wins
+func ReadMetadataSources(appConfig *config.SectionConfig, cfg *app.ClientConfig) error {
+	resolved := make(map[string]bool)
+	reasons := make(map[string][]string)
+	for i := range appConfig.Metadata.Sources {
+		src := &appConfig.Metadata.Sources[i]
+		if resolved[src.Field] {
+			continue
+		}
+		value, err := readMetadataSource(appConfig.Path, src)
+		if err != nil {
+			reason := fmt.Sprintf("%s: %v", describeMetadataSource(src), err)
+			logger.Debugf("[%s] metadata %s from %s", appConfig.AppID, src.Field, reason)
+			reasons[src.Field] = append(reasons[src.Field], reason)
+			continue
+		}
+		switch src.Field {
+		case config.MetadataFieldHost:
+			cfg.Host = value
+		case config.MetadataFieldInstanceID:
+			cfg.InstanceID = value
+		case config.MetadataFieldVersion:
+			cfg.Version = value
+		}
+		resolved[src.Field] = true
+	}
+	for _, field := range appConfig.Metadata.Required {
+		if resolved[field] {
+			continue
+		}
+		if len(reasons[field]) <= 0 {
+			return fmt.Errorf("required metadata %s is missing: no sources", field)
+		}
+		return fmt.Errorf("required metadata %s is missing: %s", field, strings.Join(reasons[field], "; "))
+	}
+	return nil
+}
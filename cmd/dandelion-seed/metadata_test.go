@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/dandelion/log"
+)
+
+func TestMain(m *testing.M) {
+	conf := config.BuildDefaultConf()
+	conf.Log.AccessLevel = "error"
+	err := log.InitLog(&conf.Log)
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseKeyPath(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := parseKeyPath("$.a.b[0][1].c")
+	assert.NoError(err)
+	assert.Equal([]interface{}{"a", "b", 0, 1, "c"}, keys)
+
+	keys, err = parseKeyPath("version")
+	assert.NoError(err)
+	assert.Equal([]interface{}{"version"}, keys)
+
+	for _, keyPath := range []string{"$", "", "$.a..b", "$.a[x]", "$.a[0", "$.a[0]b"} {
+		_, err = parseKeyPath(keyPath)
+		assert.Error(err, keyPath)
+	}
+}
+
+func TestReadMetadataSource(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dandelion-seed")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "package.json"),
+		[]byte(`{"version":"1.2.3","dependencies":{"version":"9.9.9"},"build":[10,{"host":"h1"}]}`), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "app.yml"),
+		[]byte("# version: 0.0.0\napp:\n  version: 2.0.0\n  replicas: 3\n"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, ".env"),
+		[]byte("# VERSION=0\nexport VERSION=\"3.1\"\nHOST=h2 # comment\n"), 0644))
+	require.NoError(os.Setenv("DANDELION_TEST_VERSION", "4.0"))
+	defer os.Unsetenv("DANDELION_TEST_VERSION")
+
+	cases := []struct {
+		src   config.SectionMetadataSource
+		value string
+	}{
+		{config.SectionMetadataSource{Type: "json", File: "package.json", Key: "$.version"}, "1.2.3"},
+		{config.SectionMetadataSource{Type: "json", File: "package.json", Key: "$.build[0]"}, "10"},
+		{config.SectionMetadataSource{Type: "json", File: "package.json", Key: "$.build[1].host"}, "h1"},
+		{config.SectionMetadataSource{Type: "yaml", File: "app.yml", Key: "$.app.version"}, "2.0.0"},
+		{config.SectionMetadataSource{Type: "yaml", File: filepath.Join(dir, "app.yml"), Key: "app.replicas"}, "3"},
+		{config.SectionMetadataSource{Type: "dotenv", File: ".env", Key: "VERSION"}, "3.1"},
+		{config.SectionMetadataSource{Type: "dotenv", File: ".env", Key: "HOST"}, "h2"},
+		{config.SectionMetadataSource{Type: "env", Key: "DANDELION_TEST_VERSION"}, "4.0"},
+		{config.SectionMetadataSource{Type: "command", Command: "echo 5.0", Timeout: config.DefaultMetaTimeout}, "5.0"},
+		{config.SectionMetadataSource{Type: "static", Value: "6.0"}, "6.0"},
+	}
+	for _, c := range cases {
+		value, err := readMetadataSource(dir, &c.src)
+		if assert.NoError(err, c.src) {
+			assert.Equal(c.value, value, c.src)
+		}
+	}
+
+	errCases := []config.SectionMetadataSource{
+		{Type: "json", File: "missing.json", Key: "$.version"},
+		{Type: "json", File: "package.json", Key: "$.dependencies"},
+		{Type: "json", File: "package.json", Key: "$.build[2]"},
+		{Type: "json", File: "app.yml", Key: "$.version"},
+		{Type: "yaml", File: "app.yml", Key: "$.version"},
+		{Type: "dotenv", File: ".env", Key: "INSTANCE_ID"},
+		{Type: "env", Key: "DANDELION_TEST_MISSING"},
+	}
+	for _, src := range errCases {
+		_, err := readMetadataSource(dir, &src)
+		assert.Error(err, src)
+	}
+}
+
+func TestReadMetadataSources(t *testing.T) {
+	assert := assert.New(t)
+
+	appConfig := &config.SectionConfig{
+		AppID: "test",
+		Path:  "/nonexistent",
+		Metadata: config.SectionMetadata{
+			Required: []string{"version"},
+			Sources: []config.SectionMetadataSource{
+				{Field: "version", Type: "json", File: "package.json", Key: "$.version"},
+				{Field: "version", Type: "static", Value: "1.0"},
+				{Field: "version", Type: "static", Value: "2.0"},
+				{Field: "host", Type: "env", Key: "DANDELION_TEST_MISSING"},
+			},
+		},
+	}
+	cfg := app.ClientConfig{Host: "h0", Version: "0"}
+	err := ReadMetadataSources(appConfig, &cfg)
+	assert.NoError(err)
+	assert.Equal("1.0", cfg.Version)
+	assert.Equal("h0", cfg.Host)
+
+	appConfig.Metadata.Required = []string{"version", "host"}
+	err = ReadMetadataSources(appConfig, &cfg)
+	assert.EqualError(err, "required metadata host is missing: env DANDELION_TEST_MISSING: metadata not found: DANDELION_TEST_MISSING")
+
+	appConfig.Metadata.Required = []string{"instance_id"}
+	err = ReadMetadataSources(appConfig, &cfg)
+	assert.EqualError(err, "required metadata instance_id is missing: no sources")
+}
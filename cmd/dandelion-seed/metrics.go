@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+const metricsNamespace = "dandelion_seed"
+
+var appLabels = []string{"app_id", "path"}
+
+var (
+	checkAttemptsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_attempts_total",
+		Help:      "Total number of config check attempts.",
+	}, appLabels)
+	checkSuccessesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_successes_total",
+		Help:      "Total number of successful config checks.",
+	}, appLabels)
+	checkFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_failures_total",
+		Help:      "Total number of failed config checks.",
+	}, appLabels)
+	reloadDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reload_duration_seconds",
+		Help:      "Duration of app reloads in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, appLabels)
+	lastCheckSuccessGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_check_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful config check.",
+	}, appLabels)
+	configInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_info",
+		Help:      "Current config of the app, always 1.",
+	}, append(appLabels, "config_id", "commit_id"))
+	kafkaMessagesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kafka_messages_total",
+		Help:      "Total number of handled kafka messages.",
+	})
+	websocketConnectedGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "websocket_connected",
+		Help:      "Whether the websocket is connected to dandelion server.",
+	}, func() float64 {
+		if Client != nil && Client.Connected() {
+			return 1
+		}
+		return 0
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		checkAttemptsCounter,
+		checkSuccessesCounter,
+		checkFailuresCounter,
+		reloadDurationHistogram,
+		lastCheckSuccessGauge,
+		configInfoGauge,
+		kafkaMessagesCounter,
+		websocketConnectedGauge,
+	)
+}
+
+func observeCheck(appConfig *config.SectionConfig, c *app.AppConfig, err error) {
+	checkAttemptsCounter.WithLabelValues(appConfig.AppID, appConfig.Path).Inc()
+	if err != nil {
+		checkFailuresCounter.WithLabelValues(appConfig.AppID, appConfig.Path).Inc()
+		return
+	}
+	checkSuccessesCounter.WithLabelValues(appConfig.AppID, appConfig.Path).Inc()
+	lastCheckSuccessGauge.WithLabelValues(appConfig.AppID, appConfig.Path).Set(float64(time.Now().Unix()))
+	if c != nil {
+		s := getAppState(appConfig.ID)
+		configID := strconv.FormatInt(c.ID, 10)
+		if s.metricConfigID != configID || s.metricCommitID != c.CommitID {
+			if s.metricConfigID != "" || s.metricCommitID != "" {
+				configInfoGauge.DeleteLabelValues(appConfig.AppID, appConfig.Path, s.metricConfigID, s.metricCommitID)
+			}
+			s.metricConfigID = configID
+			s.metricCommitID = c.CommitID
+		}
+		configInfoGauge.WithLabelValues(appConfig.AppID, appConfig.Path, configID, c.CommitID).Set(1)
+	}
+}
+
+func observeReload(appConfig *config.SectionConfig, d time.Duration) {
+	reloadDurationHistogram.WithLabelValues(appConfig.AppID, appConfig.Path).Observe(d.Seconds())
+}
+
+// removeAppMetrics removes metrics of unmanaged app
+func removeAppMetrics(appConfig *config.SectionConfig) {
+	checkAttemptsCounter.DeleteLabelValues(appConfig.AppID, appConfig.Path)
+	checkSuccessesCounter.DeleteLabelValues(appConfig.AppID, appConfig.Path)
+	checkFailuresCounter.DeleteLabelValues(appConfig.AppID, appConfig.Path)
+	reloadDurationHistogram.DeleteLabelValues(appConfig.AppID, appConfig.Path)
+	lastCheckSuccessGauge.DeleteLabelValues(appConfig.AppID, appConfig.Path)
+	s := getAppState(appConfig.ID)
+	if s.metricConfigID != "" || s.metricCommitID != "" {
+		configInfoGauge.DeleteLabelValues(appConfig.AppID, appConfig.Path, s.metricConfigID, s.metricCommitID)
+	}
+}
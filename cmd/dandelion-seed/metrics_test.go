@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+func TestObserveCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	appConfig := &config.SectionConfig{ID: 130, AppID: "metrics", Path: "/tmp/metrics"}
+	defer removeAppState(appConfig.ID)
+	defer removeAppMetrics(appConfig)
+
+	c := &app.AppConfig{ID: 1, AppID: "metrics", CommitID: "c1"}
+	observeCheck(appConfig, c, nil)
+	observeCheck(appConfig, c, nil)
+	observeCheck(appConfig, nil, errors.New("failed"))
+
+	assert.Equal(3.0, testutil.ToFloat64(checkAttemptsCounter.WithLabelValues("metrics", "/tmp/metrics")))
+	assert.Equal(2.0, testutil.ToFloat64(checkSuccessesCounter.WithLabelValues("metrics", "/tmp/metrics")))
+	assert.Equal(1.0, testutil.ToFloat64(checkFailuresCounter.WithLabelValues("metrics", "/tmp/metrics")))
+	assert.NotZero(testutil.ToFloat64(lastCheckSuccessGauge.WithLabelValues("metrics", "/tmp/metrics")))
+	assert.Equal(1.0, testutil.ToFloat64(configInfoGauge.WithLabelValues("metrics", "/tmp/metrics", "1", "c1")))
+
+	// the config info is replaced on config change
+	c2 := &app.AppConfig{ID: 2, AppID: "metrics", CommitID: "c2"}
+	observeCheck(appConfig, c2, nil)
+	assert.False(configInfoGauge.DeleteLabelValues("metrics", "/tmp/metrics", "1", "c1"))
+	assert.Equal(1.0, testutil.ToFloat64(configInfoGauge.WithLabelValues("metrics", "/tmp/metrics", "2", "c2")))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gobwas/glob"
+
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+// filePermission is the ownership and permission of a config file,
+// uid or gid is -1 if unchanged, mode or dirMode is 0 if unchanged
+type filePermission struct {
+	uid     int
+	gid     int
+	mode    os.FileMode
+	dirMode os.FileMode
+}
+
+type permissionRule struct {
+	match glob.Glob
+	perm  filePermission
+}
+
+// permissionRules resolves file permissions by ordered rules, the first
+// matched rule wins
+type permissionRules []permissionRule
+
+func newPermissionRule(match, owner, group, mode, dirMode string) (*permissionRule, error) {
+	g, err := glob.Compile(match, '/')
+	if err != nil {
+		return nil, err
+	}
+	r := &permissionRule{match: g, perm: filePermission{uid: -1, gid: -1}}
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return nil, err
+		}
+		r.perm.uid, _ = strconv.Atoi(u.Uid)
+		if group == "" {
+			// use the primary group of owner
+			r.perm.gid, _ = strconv.Atoi(u.Gid)
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		r.perm.gid, _ = strconv.Atoi(g.Gid)
+	}
+	if mode != "" {
+		r.perm.mode, err = config.ParseFileMode(mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dirMode != "" {
+		r.perm.dirMode, err = config.ParseFileMode(dirMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// newPermissionRules creates the permission rules of app, chown and chmod
+// act as the last rule matching all files
+func newPermissionRules(appConfig *config.SectionConfig) (permissionRules, error) {
+	var rules permissionRules
+	for _, p := range appConfig.Permissions {
+		r, err := newPermissionRule(p.Match, p.Owner, p.Group, p.Mode, p.DirMode)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *r)
+	}
+	if appConfig.Chown != "" || appConfig.Chmod != "" {
+		var owner, group string
+		if appConfig.Chown != "" {
+			parts := strings.SplitN(appConfig.Chown, ":", 2)
+			owner = parts[0]
+			if len(parts) > 1 {
+				group = parts[1]
+			}
+		}
+		r, err := newPermissionRule("**", owner, group, appConfig.Chmod, "")
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *r)
+	}
+	return rules, nil
+}
+
+// Match returns the permission of file
+func (rules permissionRules) Match(fileName string) filePermission {
+	for _, r := range rules {
+		if r.match.Match(fileName) {
+			return r.perm
+		}
+	}
+	return filePermission{uid: -1, gid: -1}
+}
+
+// chown returns whether the ownership needs to change
+func (p filePermission) chown() bool {
+	return p.uid >= 0 || p.gid >= 0
+}
+
+// parentDirs returns the parent directories of file under the app path
+func parentDirs(appPath, fileName string) []string {
+	var dirs []string
+	for dir := path.Dir(fileName); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append(dirs, path.Join(appPath, dir))
+	}
+	return dirs
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+func TestPermissionRules(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	appConfig := &config.SectionConfig{
+		AppID: "test",
+		Path:  "/tmp/test",
+		Chmod: "664",
+		Permissions: []config.SectionPermission{
+			{Match: "secrets/*", Mode: "0600", DirMode: "0700"},
+			{Match: "public/**", Mode: "0644"},
+			{Match: "secrets/**", Mode: "0640"},
+		},
+	}
+	rules, err := newPermissionRules(appConfig)
+	require.NoError(err)
+	require.Len(rules, 4)
+
+	perm := rules.Match("secrets/key.pem")
+	assert.Equal(os.FileMode(0600), perm.mode)
+	assert.Equal(os.FileMode(0700), perm.dirMode)
+	assert.False(perm.chown())
+
+	assert.Equal(os.FileMode(0640), rules.Match("secrets/sub/key.pem").mode)
+	assert.Equal(os.FileMode(0644), rules.Match("public/css/app.css").mode)
+	// falls back to chmod
+	assert.Equal(os.FileMode(0664), rules.Match("app.conf").mode)
+
+	appConfig.Chmod = ""
+	rules, err = newPermissionRules(appConfig)
+	require.NoError(err)
+	perm = rules.Match("app.conf")
+	assert.Equal(filePermission{uid: -1, gid: -1}, perm)
+
+	appConfig.Permissions = []config.SectionPermission{{Match: "*", Mode: "999"}}
+	_, err = newPermissionRules(appConfig)
+	assert.Error(err)
+}
+
+func TestParentDirs(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(parentDirs("/tmp/test", "a.conf"))
+	assert.Equal([]string{"/tmp/test/a/b", "/tmp/test/a"}, parentDirs("/tmp/test", "a/b/c.conf"))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// errors
+var (
+	ErrProcessNotFound = errors.New("process not found")
+	ErrUnknownSignal   = errors.New("unknown signal")
+)
+
+// maxReloadOutput limits the captured output of reload strategies
+const maxReloadOutput = 64 * 1024
+
+// reloadHTTPClient sends the requests of http reloaders, the timeout is
+// limited by the reload context
+var reloadHTTPClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+
+// Reloader reloads the app after its config files are synced
+type Reloader interface {
+	Reload(ctx context.Context) (string, error)
+}
+
+type noneReloader struct{}
+
+type execReloader struct {
+	command string
+}
+
+type signalReloader struct {
+	signal      string
+	pidFile     string
+	processName string
+}
+
+type httpReloader struct {
+	method string
+	url    string
+}
+
+type systemdReloader struct {
+	unit   string
+	action string
+}
+
+// NewReloader creates the reloader for specified reload strategy
+func NewReloader(conf *config.SectionReload) (Reloader, error) {
+	switch conf.Type {
+	case "", config.ReloadTypeNone:
+		return &noneReloader{}, nil
+	case config.ReloadTypeExec:
+		return &execReloader{command: conf.Command}, nil
+	case config.ReloadTypeSignal:
+		return &signalReloader{
+			signal:      conf.Signal,
+			pidFile:     conf.PIDFile,
+			processName: conf.ProcessName,
+		}, nil
+	case config.ReloadTypeHTTP:
+		return &httpReloader{method: conf.Method, url: conf.URL}, nil
+	case config.ReloadTypeSystemd:
+		return &systemdReloader{unit: conf.Unit, action: conf.Action}, nil
+	}
+	return nil, fmt.Errorf("unknown reload type %q", conf.Type)
+}
+
+func (*noneReloader) Reload(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (r *execReloader) Reload(ctx context.Context) (string, error) {
+	return runCommand(ctx, r.command, "", nil)
+}
+
+func (r *signalReloader) pids() ([]int, error) {
+	if r.pidFile != "" {
+		data, err := ioutil.ReadFile(r.pidFile)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid file %s: %v", r.pidFile, err)
+		}
+		return []int{pid}, nil
+	}
+	return findProcessesByName(r.processName)
+}
+
+func (r *signalReloader) Reload(ctx context.Context) (string, error) {
+	sig, err := parseSignal(r.signal)
+	if err != nil {
+		return "", err
+	}
+	pids, err := r.pids()
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			return strings.Join(out, "\n"), ctx.Err()
+		}
+		p, err := os.FindProcess(pid)
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			return strings.Join(out, "\n"), fmt.Errorf("send signal %s to pid %d: %v", r.signal, pid, err)
+		}
+		out = append(out, fmt.Sprintf("sent signal %s to pid %d", r.signal, pid))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func (r *httpReloader) Reload(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, nil)
+	if err != nil {
+		return "", err
+	}
+	client.InitHTTPRequest(req, false)
+
+	resp, err := reloadHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxReloadOutput))
+	out := fmt.Sprintf("HTTP %s\n%s", resp.Status, body)
+	if err != nil {
+		return out, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return out, fmt.Errorf("reload endpoint response status %d", resp.StatusCode)
+	}
+	return out, nil
+}
+
+func (r *systemdReloader) Reload(ctx context.Context) (string, error) {
+	conn, err := dbus.NewWithContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	ch := make(chan string, 1)
+	switch r.action {
+	case "restart":
+		_, err = conn.RestartUnitContext(ctx, r.unit, "replace", ch)
+	case "try-restart":
+		_, err = conn.TryRestartUnitContext(ctx, r.unit, "replace", ch)
+	case "reload":
+		_, err = conn.ReloadUnitContext(ctx, r.unit, "replace", ch)
+	case "reload-or-restart":
+		_, err = conn.ReloadOrRestartUnitContext(ctx, r.unit, "replace", ch)
+	default:
+		err = fmt.Errorf("unknown systemd action %q", r.action)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	select {
+	case result := <-ch:
+		out := fmt.Sprintf("systemd %s %s: %s", r.action, r.unit, result)
+		if result != "done" {
+			return out, fmt.Errorf("systemd job %s", result)
+		}
+		return out, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// findProcessesByName finds pids by process name from procfs
+func findProcessesByName(name string) ([]int, error) {
+	matches, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) <= 0 {
+		return nil, fmt.Errorf("%v: %s (procfs is unavailable)", ErrProcessNotFound, name)
+	}
+	var pids []int
+	for _, m := range matches {
+		comm, err := ioutil.ReadFile(m)
+		if err != nil {
+			// process exited
+			continue
+		}
+		if strings.TrimSpace(string(comm)) != name {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(m)))
+		if err == nil && pid != os.Getpid() {
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) <= 0 {
+		return nil, fmt.Errorf("%v: %s", ErrProcessNotFound, name)
+	}
+	return pids, nil
+}
+
+func parseSignal(name string) (os.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("%v: %s", ErrUnknownSignal, name)
+}
+
+// ReloadApp reloads the app by its reload strategy, the reload is canceled
+// once ctx is done
+func ReloadApp(ctx context.Context, appConfig *config.SectionConfig) (string, error) {
+	r, err := NewReloader(&appConfig.Reload)
+	if err != nil {
+		logger.Errorf("[%s] init reloader error: %v", appConfig.AppID, err)
+		return "", err
+	}
+	if _, ok := r.(*noneReloader); ok {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, appConfig.Reload.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	out, err := r.Reload(ctx)
+	observeReload(appConfig, time.Since(start))
+	if len(out) > maxReloadOutput {
+		out = out[:maxReloadOutput]
+	}
+	if len(out) > 0 {
+		logger.Infof("[%s] %s reload:\n%s", appConfig.AppID, appConfig.Reload.Type, out)
+	} else {
+		logger.Infof("[%s] %s reload", appConfig.AppID, appConfig.Reload.Type)
+	}
+	if err != nil {
+		logger.Errorf("[%s] %s reload error: %v", appConfig.AppID, appConfig.Reload.Type, err)
+		return out, err
+	}
+	return out, nil
+}
@@ -0,0 +1,116 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignal(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name     string
+		expected os.Signal
+	}{
+		{"HUP", syscall.SIGHUP},
+		{"SIGHUP", syscall.SIGHUP},
+		{"sighup", syscall.SIGHUP},
+		{"term", syscall.SIGTERM},
+		{"SIGUSR2", syscall.SIGUSR2},
+		{"SIGFOO", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		sig, err := parseSignal(tt.name)
+		if tt.expected == nil {
+			assert.Error(err, tt.name)
+			assert.Contains(err.Error(), ErrUnknownSignal.Error())
+			continue
+		}
+		assert.NoError(err, tt.name)
+		assert.Equal(tt.expected, sig, tt.name)
+	}
+}
+
+func TestHTTPReloader(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			assert.Equal(http.MethodPost, r.Method)
+			w.Write([]byte("reloaded"))
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed"))
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		path    string
+		timeout time.Duration
+		out     string
+		err     string
+	}{
+		{"/ok", time.Second, "HTTP 200 OK\nreloaded", ""},
+		{"/fail", time.Second, "HTTP 500 Internal Server Error\nfailed", "reload endpoint response status 500"},
+		{"/slow", 100 * time.Millisecond, "", context.DeadlineExceeded.Error()},
+	}
+	for _, tt := range tests {
+		r := &httpReloader{method: http.MethodPost, url: s.URL + tt.path}
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		out, err := r.Reload(ctx)
+		cancel()
+		assert.Equal(tt.out, out, tt.path)
+		if tt.err == "" {
+			assert.NoError(err, tt.path)
+		} else if assert.Error(err, tt.path) {
+			assert.Contains(err.Error(), tt.err, tt.path)
+		}
+	}
+}
+
+func TestExecReloader(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		command string
+		timeout time.Duration
+		out     string
+		err     string
+	}{
+		{"echo reloaded", time.Second, "reloaded\n", ""},
+		{"sh -c 'echo out; echo err >&2; exit 3'", time.Second, "out\nerr\n", "exit status 3"},
+		{"sleep 5", 100 * time.Millisecond, "", context.DeadlineExceeded.Error()},
+	}
+	for _, tt := range tests {
+		r := &execReloader{command: tt.command}
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		start := time.Now()
+		out, err := r.Reload(ctx)
+		cancel()
+		assert.Less(time.Since(start), 5*time.Second, tt.command)
+		assert.Equal(tt.out, out, tt.command)
+		if tt.err == "" {
+			assert.NoError(err, tt.command)
+		} else if assert.Error(err, tt.command) {
+			assert.Contains(err.Error(), tt.err, tt.command)
+		}
+	}
+}
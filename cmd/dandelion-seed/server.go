@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/tengattack/dandelion/log"
 	"github.com/tengattack/tgo/logger"
@@ -30,6 +31,19 @@ func routerEngine() *gin.Engine {
 
 	r.GET("/health", appHealthHandler)
 	r.POST("/check/:app_id", appCheckHandler)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// local status
+	local := r.Group("/", localOnly)
+	local.GET("/status", appStatusHandler)
+	local.GET("/status/:app_id", appStatusHandler)
+	local.GET("/dry-run", appDryRunHandler)
+	local.GET("/dry-run/:app_id", appDryRunHandler)
+
+	// applied config files for apps
+	local.GET("/apps/:app_id/manifest", appManifestHandler)
+	local.GET("/apps/:app_id/files/*path", appFileHandler)
+	local.GET("/apps/:app_id/watch", appWatchHandler)
 
 	return r
 }
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+// only kill is supported for windows
+var signals = map[string]os.Signal{
+	"KILL": os.Kill,
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion-seed/config"
+)
+
+// AppStatus is the local status of a managed app config
+type AppStatus struct {
+	AppID           string            `json:"app_id"`
+	Path            string            `json:"path"`
+	Status          string            `json:"status"`
+	ClientConfig    *app.ClientConfig `json:"client_config"`
+	ConfigID        int64             `json:"config_id"`
+	CommitID        string            `json:"commit_id"`
+	Files           map[string]string `json:"files"`
+	DriftFiles      []string          `json:"drift_files,omitempty"`
+	LastError       string            `json:"last_error,omitempty"`
+	ReloadOutput    string            `json:"reload_output,omitempty"`
+	HookOutputs     map[string]string `json:"hook_outputs,omitempty"`
+	LastCheckTime   int64             `json:"last_check_time"`
+	LastSyncTime    int64             `json:"last_sync_time"`
+	LastSuccessTime int64             `json:"last_success_time"`
+}
+
+// appState is the runtime state of a managed app config
+type appState struct {
+	// mu serializes checks of the app
+	mu sync.Mutex
+
+	statusLock sync.RWMutex
+	status     AppStatus
+	// syncs counts the synced times
+	syncs int
+
+	pendingLock sync.Mutex
+	pending     bool
+
+	// labels of config info metric
+	metricConfigID string
+	metricCommitID string
+}
+
+var (
+	appStates     = make(map[int]*appState)
+	appStatesLock sync.Mutex
+)
+
+// getAppState gets or creates the runtime state of specified config id
+func getAppState(id int) *appState {
+	appStatesLock.Lock()
+	defer appStatesLock.Unlock()
+	s, ok := appStates[id]
+	if !ok {
+		s = new(appState)
+		appStates[id] = s
+	}
+	return s
+}
+
+func removeAppState(id int) {
+	appStatesLock.Lock()
+	defer appStatesLock.Unlock()
+	delete(appStates, id)
+}
+
+// setPending marks a check is pending, returns false if already pending
+func (s *appState) setPending() bool {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	if s.pending {
+		return false
+	}
+	s.pending = true
+	return true
+}
+
+func (s *appState) clearPending() {
+	s.pendingLock.Lock()
+	s.pending = false
+	s.pendingLock.Unlock()
+}
+
+// Status returns a copy of current app status
+func (s *appState) Status() AppStatus {
+	s.statusLock.RLock()
+	defer s.statusLock.RUnlock()
+	st := s.status
+	if st.ClientConfig != nil {
+		cfg := *st.ClientConfig
+		st.ClientConfig = &cfg
+	}
+	if st.HookOutputs != nil {
+		outputs := make(map[string]string, len(st.HookOutputs))
+		for k, v := range st.HookOutputs {
+			outputs[k] = v
+		}
+		st.HookOutputs = outputs
+	}
+	if st.DriftFiles != nil {
+		st.DriftFiles = append([]string(nil), st.DriftFiles...)
+	}
+	if st.Files != nil {
+		files := make(map[string]string, len(st.Files))
+		for k, v := range st.Files {
+			files[k] = v
+		}
+		st.Files = files
+	}
+	return st
+}
+
+func (s *appState) setStatus(appConfig *config.SectionConfig, status client.InstanceStatus) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status.AppID = appConfig.AppID
+	s.status.Path = appConfig.Path
+	s.status.Status = status.String()
+	if status == client.StatusChecking {
+		s.status.LastCheckTime = time.Now().Unix()
+	}
+}
+
+func (s *appState) setClientConfig(cfg *app.ClientConfig) {
+	s.statusLock.Lock()
+	s.status.ClientConfig = cfg
+	s.statusLock.Unlock()
+}
+
+// setSynced records the reload output after config files synced
+func (s *appState) setSynced(out string) {
+	s.statusLock.Lock()
+	s.status.ReloadOutput = out
+	s.status.LastSyncTime = time.Now().Unix()
+	s.syncs++
+	s.statusLock.Unlock()
+}
+
+func (s *appState) setHookOutput(name, out string) {
+	s.statusLock.Lock()
+	if s.status.HookOutputs == nil {
+		s.status.HookOutputs = make(map[string]string)
+	}
+	s.status.HookOutputs[name] = out
+	s.statusLock.Unlock()
+}
+
+// setResult records the result of a check
+func (s *appState) setResult(c *app.AppConfig, files map[string]string, err error) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if c != nil {
+		s.status.ConfigID = c.ID
+		s.status.CommitID = c.CommitID
+	}
+	if err != nil {
+		s.status.Status = client.StatusError.String()
+		s.status.LastError = err.Error()
+		return
+	}
+	s.status.Status = client.StatusSuccess.String()
+	s.status.LastError = ""
+	s.status.DriftFiles = nil
+	s.status.Files = files
+	s.status.LastSuccessTime = time.Now().Unix()
+}
+
+// Syncs returns the synced times
+func (s *appState) Syncs() int {
+	s.statusLock.RLock()
+	defer s.statusLock.RUnlock()
+	return s.syncs
+}
+
+// setDrift records the drifted files, the status recovers to success if no
+// files drifted
+func (s *appState) setDrift(files []string) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if len(files) > 0 {
+		s.status.Status = client.StatusDrifted.String()
+	} else {
+		s.status.Status = client.StatusSuccess.String()
+	}
+	s.status.DriftFiles = files
+}
+
+// GetAppStatuses returns statuses of all managed app configs
+func GetAppStatuses(appID string) []AppStatus {
+	statuses := []AppStatus{}
+	for _, c := range GetConfigs() {
+		if appID != "" && c.AppID != appID {
+			continue
+		}
+		st := getAppState(c.ID).Status()
+		st.AppID = c.AppID
+		st.Path = c.Path
+		if st.Status == "" {
+			st.Status = client.StatusOffline.String()
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+
+	"github.com/tengattack/tgo/logger"
+)
+
+const (
+	// sdStatusInterval is the interval to update status text to systemd
+	sdStatusInterval = 30 * time.Second
+	// wsHungTimeout is the timeout to consider the websocket loop hung
+	wsHungTimeout = 5 * time.Minute
+)
+
+// sdNotify sends state to systemd, it does nothing if not running under
+// systemd with Type=notify
+func sdNotify(state string) {
+	_, err := daemon.SdNotify(false, state)
+	if err != nil {
+		logger.Errorf("systemd notify error: %v", err)
+	}
+}
+
+// sdStatus returns the status text of current sync state
+func sdStatus() string {
+	counts := make(map[string]int)
+	statuses := GetAppStatuses("")
+	for _, st := range statuses {
+		counts[st.Status]++
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[name], name))
+	}
+	ws := "websocket disconnected"
+	if Client.Connected() {
+		ws = "websocket connected"
+	}
+	return fmt.Sprintf("%d apps: %s; %s", len(statuses), strings.Join(parts, ", "), ws)
+}
+
+// sdTickInterval returns the interval to notify systemd, it is 0 if not
+// running under systemd
+func sdTickInterval() time.Duration {
+	sent, err := daemon.SdNotify(false, "STATUS="+sdStatus())
+	if err != nil || !sent {
+		return 0
+	}
+	d, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || d <= 0 {
+		return sdStatusInterval
+	}
+	if d/2 < sdStatusInterval {
+		return d / 2
+	}
+	return sdStatusInterval
+}
+
+// sdTick updates status to systemd and pings the watchdog if the websocket
+// loop is not hung
+func sdTick() {
+	state := "STATUS=" + sdStatus()
+	if t := Client.Active(); !t.IsZero() && time.Since(t) > wsHungTimeout {
+		logger.Errorf("websocket loop hung since %v, skipping watchdog", t)
+	} else {
+		state = "WATCHDOG=1\n" + state
+	}
+	sdNotify(state)
+}
@@ -0,0 +1,35 @@
+// Package bus relays notify messages between dandelion server replicas, so
+// every replica notifies its own websocket clients and long polls.
+package bus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+const (
+	messagesBufferSize = 64
+)
+
+// Bus relays notify messages between dandelion server replicas
+type Bus interface {
+	// Publish sends the message to other replicas
+	Publish(m *app.NotifyMessage) error
+	// Messages returns the messages from other replicas, the channel is
+	// closed when the bus is closed
+	Messages() <-chan *app.NotifyMessage
+	// Close stops the bus
+	Close() error
+}
+
+// NewReplicaID returns an unique id of current server process
+func NewReplicaID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
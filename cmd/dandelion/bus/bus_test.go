@@ -0,0 +1,113 @@
+package bus_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion/bus"
+	"github.com/tengattack/dandelion/cmd/dandelion/config"
+)
+
+func nextMessage(t *testing.T, b bus.Bus) *app.NotifyMessage {
+	select {
+	case m, ok := <-b.Messages():
+		require.True(t, ok, "messages channel closed")
+		return m
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "bus message timeout")
+	}
+	return nil
+}
+
+func testBus(t *testing.T, b1, b2, b3 bus.Bus) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	require.NoError(b1.Publish(&app.NotifyMessage{AppID: "a1", Event: "publish", Config: &app.AppConfig{ID: 1}}))
+	require.NoError(b2.Publish(&app.NotifyMessage{AppID: "a2", Event: "check"}))
+
+	m := nextMessage(t, b2)
+	assert.Equal("a1", m.AppID)
+	assert.Equal(int64(1), m.Config.ID)
+	assert.Equal("a2", nextMessage(t, b1).AppID)
+	assert.Equal("a1", nextMessage(t, b3).AppID)
+	assert.Equal("a2", nextMessage(t, b3).AppID)
+
+	// own messages are not relayed
+	select {
+	case m := <-b1.Messages():
+		assert.Failf("unexpected message", "%v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(b3.Close())
+	_, ok := <-b3.Messages()
+	assert.False(ok)
+	require.NoError(b1.Publish(&app.NotifyMessage{AppID: "a3", Event: "check"}))
+	assert.Equal("a3", nextMessage(t, b2).AppID)
+
+	require.NoError(b1.Close())
+	require.NoError(b2.Close())
+}
+
+func TestMemoryBus(t *testing.T) {
+	hub := bus.NewMemoryHub()
+	testBus(t, hub.NewBus(), hub.NewBus(), hub.NewBus())
+}
+
+func TestDBBus(t *testing.T) {
+	require := require.New(t)
+
+	config.InitTest()
+	table := config.Conf.Database.TablePrefix + "dandelion_bus_messages"
+
+	// published before created
+	b0, err := bus.NewDBBus(config.DB, table, bus.NewReplicaID(), 10*time.Millisecond)
+	require.NoError(err)
+	require.NoError(b0.Publish(&app.NotifyMessage{AppID: "a0", Event: "check"}))
+	require.NoError(b0.Close())
+
+	var buses [3]bus.Bus
+	for i := range buses {
+		buses[i], err = bus.NewDBBus(config.DB, table, bus.NewReplicaID(), 10*time.Millisecond)
+		require.NoError(err)
+	}
+	testBus(t, buses[0], buses[1], buses[2])
+}
+
+func TestDBBusOutOfOrder(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	config.InitTest()
+	table := config.Conf.Database.TablePrefix + "dandelion_bus_messages"
+	insert := func(id int64, appID string) {
+		_, err := config.DB.Exec("INSERT INTO "+table+" (id, replica_id, message, created_time) VALUES (?, ?, ?, ?)",
+			id, "other", `{"event":"check","app_id":"`+appID+`"}`, time.Now().Unix())
+		require.NoError(err)
+	}
+
+	var lastID int64
+	require.NoError(config.DB.Get(&lastID, "SELECT COALESCE(MAX(id), 0) FROM "+table))
+	insert(lastID+1, "a0")
+	b, err := bus.NewDBBus(config.DB, table, bus.NewReplicaID(), 10*time.Millisecond)
+	require.NoError(err)
+	defer b.Close()
+
+	insert(lastID+10, "a1")
+	assert.Equal("a1", nextMessage(t, b).AppID)
+	// committed after a greater id
+	insert(lastID+5, "a2")
+	assert.Equal("a2", nextMessage(t, b).AppID)
+
+	// relayed once
+	select {
+	case m := <-b.Messages():
+		assert.Failf("unexpected message", "%v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,182 @@
+package bus
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/tgo/logger"
+)
+
+const (
+	// DefaultPollInterval is the default interval of db bus polling messages
+	DefaultPollInterval = time.Second
+
+	pollLimit     = 100
+	retention     = time.Hour
+	pruneInterval = 10 * time.Minute
+	// pollWindow is the number of ids before the last id polled again, as
+	// auto increment ids may be committed out of order
+	pollWindow = 100
+)
+
+// dbMessage is a row of the bus messages table
+type dbMessage struct {
+	ID          int64  `db:"id"`
+	ReplicaID   string `db:"replica_id"`
+	Message     string `db:"message"`
+	CreatedTime int64  `db:"created_time"`
+}
+
+type dbBus struct {
+	db        *sqlx.DB
+	table     string
+	replicaID string
+	interval  time.Duration
+	lastID    int64
+	seen      map[int64]bool
+	pruned    time.Time
+	pruneLock sync.Mutex
+
+	messages  chan *app.NotifyMessage
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewDBBus creates a bus which inserts messages into the table, and polls
+// the messages of other replicas every interval. Messages published before
+// it is created are skipped.
+func NewDBBus(db *sqlx.DB, table, replicaID string, interval time.Duration) (Bus, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	b := &dbBus{
+		db:        db,
+		table:     table,
+		replicaID: replicaID,
+		interval:  interval,
+		seen:      make(map[int64]bool),
+		messages:  make(chan *app.NotifyMessage, messagesBufferSize),
+		closeCh:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	err := db.Get(&b.lastID, "SELECT COALESCE(MAX(id), 0) FROM "+table)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	err = db.Select(&ids, "SELECT id FROM "+table+" WHERE id > ?", b.lastID-pollWindow)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		b.seen[id] = true
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *dbBus) Publish(m *app.NotifyMessage) error {
+	message, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	_, err = b.db.Exec("INSERT INTO "+b.table+" (replica_id, message, created_time) VALUES (?, ?, ?)",
+		b.replicaID, string(message), t.Unix())
+	if err != nil {
+		return err
+	}
+
+	b.pruneLock.Lock()
+	defer b.pruneLock.Unlock()
+	if t.Sub(b.pruned) >= pruneInterval {
+		b.pruned = t
+		_, err = b.db.Exec("DELETE FROM "+b.table+" WHERE created_time < ?", t.Add(-retention).Unix())
+		if err != nil {
+			logger.Errorf("bus prune messages error: %v", err)
+			// PASS
+		}
+	}
+	return nil
+}
+
+func (b *dbBus) Messages() <-chan *app.NotifyMessage {
+	return b.messages
+}
+
+func (b *dbBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closeCh)
+		<-b.done
+	})
+	return nil
+}
+
+func (b *dbBus) run() {
+	defer close(b.done)
+	defer close(b.messages)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := b.poll()
+			if err != nil {
+				logger.Errorf("bus poll messages error: %v", err)
+			}
+		case <-b.closeCh:
+			return
+		}
+	}
+}
+
+// poll relays the new messages of other replicas, the messages in window
+// before the last id are polled again and skipped if seen
+func (b *dbBus) poll() error {
+	from := b.lastID - pollWindow
+	for {
+		var rows []dbMessage
+		err := b.db.Select(&rows, "SELECT * FROM "+b.table+" WHERE id > ? ORDER BY id LIMIT ?", from, pollLimit)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			from = row.ID
+			if b.seen[row.ID] {
+				continue
+			}
+			b.seen[row.ID] = true
+			if row.ID > b.lastID {
+				b.lastID = row.ID
+			}
+			if row.ReplicaID == b.replicaID {
+				continue
+			}
+			var m app.NotifyMessage
+			err = json.Unmarshal([]byte(row.Message), &m)
+			if err != nil {
+				logger.Warnf("bus message %d decode error: %v", row.ID, err)
+				continue
+			}
+			select {
+			case b.messages <- &m:
+			case <-b.closeCh:
+				return nil
+			}
+		}
+		if len(rows) < pollLimit {
+			break
+		}
+	}
+	for id := range b.seen {
+		if id <= b.lastID-pollWindow {
+			delete(b.seen, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package bus
+
+import (
+	"sync"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+// MemoryHub connects memory buses in process, each bus acts as a replica
+type MemoryHub struct {
+	lock  sync.Mutex
+	buses map[*memoryBus]struct{}
+}
+
+type memoryBus struct {
+	hub       *MemoryHub
+	messages  chan *app.NotifyMessage
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemoryHub creates a hub of memory buses
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{buses: make(map[*memoryBus]struct{})}
+}
+
+// NewBus creates a memory bus joined the hub
+func (h *MemoryHub) NewBus() Bus {
+	b := &memoryBus{
+		hub:      h,
+		messages: make(chan *app.NotifyMessage, messagesBufferSize),
+		closeCh:  make(chan struct{}),
+	}
+	h.lock.Lock()
+	h.buses[b] = struct{}{}
+	h.lock.Unlock()
+	return b
+}
+
+func (b *memoryBus) Publish(m *app.NotifyMessage) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	for other := range b.hub.buses {
+		if other == b {
+			continue
+		}
+		mc := *m
+		select {
+		case other.messages <- &mc:
+		case <-other.closeCh:
+		}
+	}
+	return nil
+}
+
+func (b *memoryBus) Messages() <-chan *app.NotifyMessage {
+	return b.messages
+}
+
+func (b *memoryBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closeCh)
+		b.hub.lock.Lock()
+		delete(b.hub.buses, b)
+		close(b.messages)
+		b.hub.lock.Unlock()
+	})
+	return nil
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"io/ioutil"
 	"runtime"
+	"time"
 
+	"github.com/tengattack/dandelion/cmd/dandelion/bus"
 	"github.com/tengattack/dandelion/repository"
 	"github.com/tengattack/tgo/log"
 	"gopkg.in/yaml.v2"
@@ -16,6 +18,7 @@ type Config struct {
 	Repository    repository.Config    `yaml:"repository"`
 	Database      SectionDatabase      `yaml:"database"`
 	Kafka         SectionKafka         `yaml:"kafka"`
+	Bus           SectionBus           `yaml:"bus"`
 	Kubernetes    SectionKubernetes    `yaml:"kubernetes"`
 	CloudProvider SectionCloudProvider `yaml:"cloud_provider"`
 	Registry      SectionRegistry      `yaml:"registry"`
@@ -54,6 +57,12 @@ type SectionKafka struct {
 	Servers []string `yaml:"servers"`
 }
 
+// SectionBus is sub section of config.
+type SectionBus struct {
+	Type     string        `yaml:"type"`
+	Interval time.Duration `yaml:"interval"`
+}
+
 // SectionKubernetes is sub section of config.
 type SectionKubernetes struct {
 	InCluster      bool   `yaml:"in_cluster"`
@@ -118,6 +127,10 @@ func BuildDefaultConf() Config {
 	conf.Kafka.Enabled = false
 	conf.Kafka.Topic = ""
 
+	// Bus
+	conf.Bus.Type = ""
+	conf.Bus.Interval = bus.DefaultPollInterval
+
 	// Kubernetes
 	conf.Kubernetes.Namespace = "default"
 	conf.Kubernetes.NodeNameFormat = ""
@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/jmoiron/sqlx"
+	"github.com/tengattack/dandelion/cmd/dandelion/bus"
 	"github.com/tengattack/dandelion/mq"
 	"github.com/tengattack/dandelion/repository"
 )
@@ -15,4 +16,6 @@ var (
 	DB *sqlx.DB
 	// MQ is MessageQueue
 	MQ *mq.MessageQueue
+	// Bus relays notify messages between server replicas
+	Bus bus.Bus
 )
@@ -3,6 +3,7 @@ package controllers
 import (
 	"archive/zip"
 	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"io"
@@ -28,21 +29,6 @@ import (
 	"github.com/tengattack/tgo/logger"
 )
 
-// CommitAuthor is app config commit author structure
-type CommitAuthor struct {
-	Name  string    `json:"name"`
-	Email string    `json:"email"`
-	When  time.Time `json:"when"`
-}
-
-// Commit is app config commit structure
-type Commit struct {
-	Branch   string       `json:"branch"`
-	CommitID string       `json:"commit_id"`
-	Message  string       `json:"message"`
-	Author   CommitAuthor `json:"author"`
-}
-
 // AppConfigEvent for deployment status
 type AppConfigEvent struct {
 	Name   string         `json:"name"`
@@ -130,12 +116,12 @@ func getAppIDs() ([]string, error) {
 	return appIDs, nil
 }
 
-func getAppCommit(branch string, commit *object.Commit) Commit {
-	return Commit{
+func getAppCommit(branch string, commit *object.Commit) app.Commit {
+	return app.Commit{
 		Branch:   branch,
 		CommitID: commit.ID().String(),
 		Message:  commit.Message,
-		Author: CommitAuthor{
+		Author: app.CommitAuthor{
 			Name:  commit.Author.Name,
 			Email: commit.Author.Email,
 			When:  commit.Author.When,
@@ -333,10 +319,12 @@ func appPublishConfigHandler(c *gin.Context) {
 		return
 	}
 
+	broadcast, _ := strconv.ParseBool(c.PostForm("broadcast"))
 	m := app.NotifyMessage{
-		AppID:  appID,
-		Event:  "publish",
-		Config: &appConfig,
+		AppID:     appID,
+		Event:     "publish",
+		Config:    &appConfig,
+		Broadcast: broadcast,
 	}
 	notifyConn(&m)
 	notifyAppConfigEvent(&m)
@@ -383,11 +371,13 @@ func appRollbackConfigHandler(c *gin.Context) {
 		return
 	}
 
-	// rollback, notify all nodes
+	// rollback, notify the nodes matched or using the config
+	broadcast, _ := strconv.ParseBool(c.PostForm("broadcast"))
 	m := app.NotifyMessage{
-		AppID:  appID,
-		Event:  "rollback",
-		Config: &appConfig,
+		AppID:     appID,
+		Event:     "rollback",
+		Config:    &appConfig,
+		Broadcast: broadcast,
 	}
 	notifyConn(&m)
 	notifyAppConfigEvent(&m)
@@ -489,7 +479,7 @@ func appListCommitsHandler(c *gin.Context) {
 		return
 	}
 
-	var r []Commit
+	var r []app.Commit
 
 	branchCount := 0
 	branches, err := getBranches(false)
@@ -652,28 +642,32 @@ func appGetFileHandler(c *gin.Context) {
 		return
 	}
 
-	fr, err := f.Reader()
-	if err != nil {
-		logger.Errorf("get file error: %v", err)
-		abortWithError(c, http.StatusInternalServerError, err.Error())
-		return
-	}
-	defer fr.Close()
-
-	d, err := ioutil.ReadAll(fr)
+	sum, err := blobChecksum(f)
 	if err != nil {
 		logger.Errorf("read file error: %v", err)
 		abortWithError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.Data(http.StatusOK, "text/plain", d)
+	fr := newBlobReadSeeker(f)
+	defer fr.Close()
+
+	// the content of file is addressed by its blob hash
+	c.Header("Content-Type", "text/plain")
+	c.Header("ETag", `"`+f.Hash.String()+`"`)
+	c.Header(client.ChecksumHeader, sum)
+	http.ServeContent(c.Writer, c.Request, path, commit.Author.When, fr)
 }
 
 func buildArchive(appID, commitID, archiveFilePath string) error {
 	lArchive.Lock()
 	defer lArchive.Unlock()
 
+	if _, err := os.Stat(archiveFilePath); err == nil {
+		// built by another request
+		return nil
+	}
+
 	logger.Infof("building archive for %s/%s", appID, commitID)
 
 	l.Lock()
@@ -695,14 +689,18 @@ func buildArchive(appID, commitID, archiveFilePath string) error {
 		return err
 	}
 
-	z, err := os.Create(archiveFilePath)
+	// build into temp file, so the archive is never served partially
+	z, err := ioutil.TempFile(path.Dir(archiveFilePath), path.Base(archiveFilePath)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer z.Close()
+	defer func() {
+		z.Close()
+		os.Remove(z.Name())
+	}()
 
-	zw := zip.NewWriter(z)
-	defer zw.Close()
+	h := sha256.New()
+	zw := zip.NewWriter(io.MultiWriter(z, h))
 
 	// ... get the files iterator and print the file
 	err = tree.Files().ForEach(func(f *object.File) error {
@@ -716,6 +714,11 @@ func buildArchive(appID, commitID, archiveFilePath string) error {
 			UncompressedSize64: uint64(f.Size),
 		}
 		fh.SetModTime(commit.Author.When)
+		mode, err := f.Mode.ToOSFileMode()
+		if err == nil {
+			// keep executable bits
+			fh.SetMode(mode)
+		}
 		fw, err := zw.CreateHeader(fh)
 		if err != nil {
 			return err
@@ -724,11 +727,27 @@ func buildArchive(appID, commitID, archiveFilePath string) error {
 		if err != nil {
 			return err
 		}
+		defer fr.Close()
 		_, err = io.Copy(fw, fr)
 		return err
 	})
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	err = z.Close()
+	if err != nil {
+		return err
+	}
 
-	return err
+	err = ioutil.WriteFile(archiveFilePath+checksumFileExt, []byte(hex.EncodeToString(h.Sum(nil))), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(z.Name(), archiveFilePath)
 }
 
 func appGetArchiveHandler(c *gin.Context) {
@@ -775,30 +794,68 @@ func appGetArchiveHandler(c *gin.Context) {
 	}
 	defer f.Close()
 
-	d, err := ioutil.ReadAll(f)
+	fi, err := f.Stat()
+	if err != nil {
+		logger.Errorf("stat archive file error: %v", err)
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sum, err := archiveChecksum(archiveFilePath)
 	if err != nil {
-		logger.Errorf("read archive file error: %v", err)
+		logger.Errorf("archive checksum error: %v", err)
 		abortWithError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.Data(http.StatusOK, "application/octet-stream", d)
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("ETag", `"`+sum+`"`)
+	c.Header(client.ChecksumHeader, sum)
+	http.ServeContent(c.Writer, c.Request, fi.Name(), fi.ModTime(), f)
 }
 
 func appListInstancesHandler(c *gin.Context) {
 	appID := c.Param("app_id")
 
+	sel, err := app.ParseSelector(c.Query("selector"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	var statuses []app.Status
 	// show active instances from last day
 	t := time.Now().AddDate(0, 0, -1).Unix()
-	err := config.DB.Select(&statuses, "SELECT * FROM "+TableNameInstances()+" WHERE app_id = ? AND updated_time >= ? ORDER BY updated_time DESC",
+	err = config.DB.Select(&statuses, "SELECT * FROM "+TableNameInstances()+" WHERE app_id = ? AND updated_time >= ? ORDER BY updated_time DESC",
 		appID, t)
 	if err != nil {
 		logger.Errorf("db select error: %v", err)
 		abortWithError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if statuses == nil {
+	if len(sel) > 0 {
+		// filter instances by labels of their hosts, which are joined by the
+		// host name, the metadata host of instances may differ from the
+		// inventory host if configured differently
+		hosts, err := selectHosts(sel)
+		if err != nil {
+			logger.Errorf("db select error: %v", err)
+			abortWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		matched := make(map[string]bool, len(hosts))
+		for _, h := range hosts {
+			matched[h.Host] = true
+		}
+		j := 0
+		for _, s := range statuses {
+			if matched[s.Host] {
+				statuses[j] = s
+				j++
+			}
+		}
+		statuses = statuses[:j]
+	}
+	if len(statuses) <= 0 {
 		// ensure empty array
 		statuses = []app.Status{}
 	} else {
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const checksumFileExt = ".sha256"
+
+// blobReadSeeker is a seekable reader of git file for http.ServeContent, it
+// streams the blob and reopens it when seeking backwards
+type blobReadSeeker struct {
+	f      *object.File
+	r      io.ReadCloser
+	pos    int64
+	offset int64
+}
+
+func newBlobReadSeeker(f *object.File) *blobReadSeeker {
+	return &blobReadSeeker{f: f}
+}
+
+func (b *blobReadSeeker) Read(p []byte) (int, error) {
+	if b.r == nil || b.pos > b.offset {
+		if b.r != nil {
+			b.r.Close()
+		}
+		r, err := b.f.Reader()
+		if err != nil {
+			return 0, err
+		}
+		b.r = r
+		b.pos = 0
+	}
+	if b.pos < b.offset {
+		n, err := io.CopyN(ioutil.Discard, b.r, b.offset-b.pos)
+		b.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.r.Read(p)
+	b.pos += int64(n)
+	b.offset = b.pos
+	return n, err
+}
+
+func (b *blobReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.f.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start")
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+func (b *blobReadSeeker) Close() error {
+	if b.r == nil {
+		return nil
+	}
+	return b.r.Close()
+}
+
+// checksum returns the hex encoded sha256 of r
+func checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// blobChecksum returns the hex encoded sha256 of git file
+func blobChecksum(f *object.File) (string, error) {
+	r, err := f.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return checksum(r)
+}
+
+// archiveChecksum returns the hex encoded sha256 of archive, it is saved
+// beside the archive when built or first requested
+func archiveChecksum(archiveFilePath string) (string, error) {
+	checksumFilePath := archiveFilePath + checksumFileExt
+	data, err := ioutil.ReadFile(checksumFilePath)
+	if err == nil && len(data) == sha256.Size*2 {
+		return string(data), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	f, err := os.Open(archiveFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum, err := checksum(f)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(checksumFilePath, []byte(sum), 0644)
+	if err != nil {
+		return "", err
+	}
+	return sum, nil
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobReadSeeker(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	content := "0123456789abcdef"
+	s := memory.NewStorage()
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	require.NoError(err)
+	w.Write([]byte(content))
+	w.Close()
+	h, err := s.SetEncodedObject(obj)
+	require.NoError(err)
+	blob, err := object.GetBlob(s, h)
+	require.NoError(err)
+	f := object.NewFile("a.txt", filemode.Regular, blob)
+
+	sum, err := blobChecksum(f)
+	require.NoError(err)
+	assert.Equal("9f9f5111f7b27a781f1f1ddde5ebc2dd2b796bfc7365c9c28b548e564176929f", sum)
+
+	serve := func(rangeHeader string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		fr := newBlobReadSeeker(f)
+		defer fr.Close()
+		http.ServeContent(rec, r, f.Name, time.Time{}, fr)
+		return rec
+	}
+
+	rec := serve("")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(content, rec.Body.String())
+
+	rec = serve("bytes=10-")
+	assert.Equal(http.StatusPartialContent, rec.Code)
+	assert.Equal("abcdef", rec.Body.String())
+
+	rec = serve("bytes=2-4,12-13")
+	assert.Equal(http.StatusPartialContent, rec.Code)
+	body, _ := ioutil.ReadAll(rec.Body)
+	assert.True(strings.Contains(string(body), "234"))
+	assert.True(strings.Contains(string(body), "cd"))
+}
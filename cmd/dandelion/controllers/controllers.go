@@ -42,6 +42,9 @@ func InitHandlers() (*gin.Engine, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.Bus != nil {
+		go relayBusMessages(config.Bus)
+	}
 	return routerEngine(), nil
 }
 
@@ -137,6 +140,11 @@ func routerEngine() *gin.Engine {
 	g.POST("/rollback/:app_id", appRollbackConfigHandler)
 	g.GET("/match/:app_id", appMatchConfigHandler)
 	g.POST("/check/:app_id", appCheckHandler)
+	g.GET("/watch/:app_id", appWatchHandler)
+	g.POST("/status", statusHandler)
+
+	// host
+	g.GET("/hosts", hostListHandler)
 
 	// kube
 	g.GET("/kube/list", kubeListHandler)
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+const (
+	// watchTimeout is the default and max wait duration of long poll
+	watchTimeout = 60 * time.Second
+	// watchLimit limits the messages responded by each long poll
+	watchLimit = 100
+
+	eventsRetention     = 24 * time.Hour
+	eventsPruneInterval = time.Hour
+)
+
+// appEvent is a notify message in the event sequence
+type appEvent struct {
+	ID          int64  `db:"id"`
+	AppID       string `db:"app_id"`
+	Event       string `db:"event"`
+	Message     string `db:"message"`
+	CreatedTime int64  `db:"created_time"`
+}
+
+var (
+	eventWaiters      = make(map[string]chan struct{})
+	eventWaitersMutex sync.Mutex
+	eventsPrunedTime  int64
+)
+
+// TableNameEvents the app events table
+func TableNameEvents() string {
+	return config.Conf.Database.TablePrefix + "dandelion_app_events"
+}
+
+// appendEvent appends the notify message to the event sequence of app and
+// wakes up the long polls of app
+func appendEvent(m *app.NotifyMessage, message []byte) error {
+	defer wakeEventWaiters(m.AppID)
+
+	t := time.Now()
+	_, err := config.DB.Exec("INSERT INTO "+TableNameEvents()+" (app_id, event, message, created_time) VALUES (?, ?, ?, ?)",
+		m.AppID, m.Event, string(message), t.Unix())
+	if err != nil {
+		return err
+	}
+
+	last := atomic.LoadInt64(&eventsPrunedTime)
+	if t.Unix()-last >= int64(eventsPruneInterval/time.Second) && atomic.CompareAndSwapInt64(&eventsPrunedTime, last, t.Unix()) {
+		_, err = config.DB.Exec("DELETE FROM "+TableNameEvents()+" WHERE created_time < ?", t.Add(-eventsRetention).Unix())
+		if err != nil {
+			logger.Errorf("prune events error: %v", err)
+			// PASS
+		}
+	}
+	return nil
+}
+
+// eventWaiter returns the channel which is closed on the next event of app
+func eventWaiter(appID string) <-chan struct{} {
+	eventWaitersMutex.Lock()
+	defer eventWaitersMutex.Unlock()
+	ch, ok := eventWaiters[appID]
+	if !ok {
+		ch = make(chan struct{})
+		eventWaiters[appID] = ch
+	}
+	return ch
+}
+
+func wakeEventWaiters(appID string) {
+	eventWaitersMutex.Lock()
+	defer eventWaitersMutex.Unlock()
+	if ch, ok := eventWaiters[appID]; ok {
+		close(ch)
+		delete(eventWaiters, appID)
+	}
+}
+
+// selectEvents selects the notify messages of app after since
+func selectEvents(appID string, since int64) (*app.WatchResult, error) {
+	var rows []appEvent
+	err := config.DB.Select(&rows, "SELECT * FROM "+TableNameEvents()+" WHERE app_id = ? AND id > ? ORDER BY id LIMIT ?",
+		appID, since, watchLimit)
+	if err != nil {
+		return nil, err
+	}
+	r := &app.WatchResult{
+		AppID:    appID,
+		LastID:   since,
+		Messages: make([]app.NotifyMessage, 0, len(rows)),
+	}
+	for _, row := range rows {
+		r.LastID = row.ID
+		var m app.NotifyMessage
+		err = json.Unmarshal([]byte(row.Message), &m)
+		if err != nil {
+			logger.Warnf("event %d decode message error: %v", row.ID, err)
+			continue
+		}
+		r.Messages = append(r.Messages, m)
+	}
+	return r, nil
+}
+
+func appWatchHandler(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	timeout := watchTimeout
+	if s := c.Query("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			abortWithError(c, http.StatusBadRequest, ParamsError)
+			return
+		}
+		if d < timeout {
+			timeout = d
+		}
+	}
+
+	since := c.Query("since")
+	if since == "" {
+		// responds the last event id immediately as the start of watching
+		var lastID int64
+		err := config.DB.Get(&lastID, "SELECT COALESCE(MAX(id), 0) FROM "+TableNameEvents()+" WHERE app_id = ?", appID)
+		if err != nil {
+			logger.Errorf("db select error: %v", err)
+			abortWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		succeed(c, &app.WatchResult{AppID: appID, LastID: lastID, Messages: []app.NotifyMessage{}})
+		return
+	}
+	sinceID, err := strconv.ParseInt(since, 10, 64)
+	if err != nil || sinceID < 0 {
+		abortWithError(c, http.StatusBadRequest, ParamsError)
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	expired := false
+	for {
+		// wait before select to not miss the events in between
+		ch := eventWaiter(appID)
+		r, err := selectEvents(appID, sinceID)
+		if err != nil {
+			logger.Errorf("db select error: %v", err)
+			abortWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if r.LastID > sinceID || expired {
+			succeed(c, r)
+			return
+		}
+		select {
+		case <-ch:
+		case <-timer.C:
+			// events appended by other servers are selected at last
+			expired = true
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion/config"
+)
+
+func watch(t *testing.T, r http.Handler, query string) (int, *app.WatchResult) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/watch/w1?"+query, nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+
+	var resp struct {
+		Code int              `json:"code"`
+		Info *app.WatchResult `json:"info"`
+	}
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp.Info
+}
+
+func TestAppWatchHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/watch/:app_id", appWatchHandler)
+
+	code, _ := watch(t, r, "since=0&timeout=abc")
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = watch(t, r, "since=-1")
+	assert.Equal(http.StatusBadRequest, code)
+
+	notifyConn(&app.NotifyMessage{AppID: "w2", Event: "check"})
+
+	code, res := watch(t, r, "")
+	require.Equal(http.StatusOK, code)
+	since := res.LastID
+	assert.Empty(res.Messages)
+
+	// timeout
+	code, res = watch(t, r, "since="+strconv.FormatInt(since, 10)+"&timeout=10ms")
+	require.Equal(http.StatusOK, code)
+	assert.Equal(since, res.LastID)
+	assert.Empty(res.Messages)
+
+	// woken up by publish
+	done := make(chan *app.WatchResult, 1)
+	go func() {
+		_, res := watch(t, r, "since="+strconv.FormatInt(since, 10)+"&timeout=5s")
+		done <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+	notifyConn(&app.NotifyMessage{AppID: "w1", Event: "publish", Config: &app.AppConfig{ID: 1, AppID: "w1", CommitID: "abc"}})
+	notifyConn(&app.NotifyMessage{AppID: "w1", Event: "check"})
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("watch timeout")
+	}
+	require.NotEmpty(res.Messages)
+	assert.Equal("publish", res.Messages[0].Event)
+	assert.Equal("abc", res.Messages[0].Config.CommitID)
+	assert.Greater(res.LastID, since)
+
+	code, res = watch(t, r, "since="+strconv.FormatInt(since, 10))
+	require.Equal(http.StatusOK, code)
+	require.Len(res.Messages, 2)
+	assert.Equal("check", res.Messages[1].Event)
+	assert.Equal("w1", res.Messages[1].AppID)
+}
+
+func TestStatusHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/v1/status", statusHandler)
+
+	post := func(form url.Values) int {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "/api/v1/status", strings.NewReader(form.Encode()))
+		require.NoError(err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(http.StatusBadRequest, post(url.Values{"action": {"status"}, "payload": {`""`}}))
+	assert.Equal(http.StatusOK, post(url.Values{"action": {"status"}, "payload": {`{"app_id":"p1","host":"host1","instance_id":"instance1","config_id":1,"status":3}`}}))
+	assert.Equal(http.StatusOK, post(url.Values{"action": {"ping"}, "payload": {`[{"app_id":"p1","host":"host1","instance_id":"instance1","config_id":1,"status":4}]`}}))
+
+	var row app.Status
+	err := config.DB.Get(&row, "SELECT * FROM "+TableNameInstances()+" WHERE app_id = ? AND host = ? AND instance_id = ?",
+		"p1", "host1", "instance1")
+	require.NoError(err)
+	assert.Equal(4, row.Status)
+	assert.Equal(int64(1), row.ConfigID)
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion/config"
+	"github.com/tengattack/tgo/logger"
+)
+
+// TableNameHosts the seed hosts table
+func TableNameHosts() string {
+	return config.Conf.Database.TablePrefix + "dandelion_hosts"
+}
+
+// updateHostInventory creates or updates the inventory record of host
+func updateHostInventory(inv *app.Inventory) error {
+	var row app.Inventory
+	err := config.DB.Get(&row, "SELECT id, created_time FROM "+TableNameHosts()+" WHERE host = ? LIMIT 1", inv.Host)
+	if err == sql.ErrNoRows {
+		inv.CreatedTime = time.Now().Unix()
+		inv.UpdatedTime = inv.CreatedTime
+		_, err = config.DB.NamedExec("INSERT INTO "+TableNameHosts()+" (host, version, os, arch, uptime, labels, apps, created_time, updated_time)"+
+			" VALUES (:host, :version, :os, :arch, :uptime, :labels, :apps, :created_time, :updated_time)", inv)
+		if err != nil {
+			logger.Errorf("create new host record failed: %v", err)
+			return err
+		}
+		return nil
+	} else if err != nil {
+		logger.Errorf("get host record failed: %v", err)
+		return err
+	}
+
+	inv.ID = row.ID
+	inv.CreatedTime = row.CreatedTime
+	inv.UpdatedTime = time.Now().Unix()
+	_, err = config.DB.NamedExec("UPDATE "+TableNameHosts()+
+		" SET version = :version, os = :os, arch = :arch, uptime = :uptime, labels = :labels, apps = :apps, updated_time = :updated_time"+
+		" WHERE id = :id", inv)
+	if err != nil {
+		logger.Errorf("update host record failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// selectHosts selects the hosts active from last day which match selector
+func selectHosts(sel app.Selector) ([]app.Inventory, error) {
+	var rows []app.Inventory
+	t := time.Now().AddDate(0, 0, -1).Unix()
+	err := config.DB.Select(&rows, "SELECT * FROM "+TableNameHosts()+" WHERE updated_time >= ? ORDER BY host", t)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []app.Inventory{}
+	for _, row := range rows {
+		if sel.Match(row.Labels) {
+			hosts = append(hosts, row)
+		}
+	}
+	return hosts, nil
+}
+
+func hostListHandler(c *gin.Context) {
+	sel, err := app.ParseSelector(c.Query("selector"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hosts, err := selectHosts(sel)
+	if err != nil {
+		logger.Errorf("db select error: %v", err)
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	succeed(c, gin.H{
+		"hosts": hosts,
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/retry"
 
+	"github.com/tengattack/dandelion/app"
 	"github.com/tengattack/dandelion/cmd/dandelion/cloudprovider"
 	"github.com/tengattack/dandelion/cmd/dandelion/config"
 	"github.com/tengattack/dandelion/cmd/dandelion/registry"
@@ -40,22 +41,6 @@ type PatchStringValue struct {
 	Value interface{} `json:"value"`
 }
 
-// Deployment for kube deployment
-type Deployment struct {
-	Name      string `json:"name"`
-	ImageName string `json:"image_name"`
-	Image     string `json:"image"`
-	Replicas  int    `json:"replicas"`
-	Revision  int64  `json:"revision"`
-}
-
-// HPA for kube hpa
-type HPA struct {
-	Name        string `json:"name"`
-	MinReplicas int    `json:"min_replicas"`
-	MaxReplicas int    `json:"max_replicas"`
-}
-
 // DeploymentEvent for deployment status
 type DeploymentEvent struct {
 	Name   string                              `json:"name"`
@@ -165,9 +150,9 @@ func isManaged(dp *appsv1.Deployment) bool {
 	return ok
 }
 
-func getDeployment(dp *appsv1.Deployment) *Deployment {
+func getDeployment(dp *appsv1.Deployment) *app.Deployment {
 	revision, _ := strconv.ParseInt(dp.Annotations[RevisionAnnotation], 10, 64)
-	d := Deployment{
+	d := app.Deployment{
 		Name:      dp.Name,
 		ImageName: getImageName(dp),
 		Replicas:  int(*dp.Spec.Replicas),
@@ -179,11 +164,11 @@ func getDeployment(dp *appsv1.Deployment) *Deployment {
 	return &d
 }
 
-func getHPA(hpa *v2beta2.HorizontalPodAutoscaler) *HPA {
+func getHPA(hpa *v2beta2.HorizontalPodAutoscaler) *app.HPA {
 	if hpa == nil {
 		return nil
 	}
-	h := HPA{
+	h := app.HPA{
 		Name:        hpa.Name,
 		MaxReplicas: int(hpa.Spec.MaxReplicas),
 	}
@@ -200,7 +185,7 @@ func kubeListHandler(c *gin.Context) {
 		return
 	}
 
-	ds := make([]*Deployment, 0, len(list.Items))
+	ds := make([]*app.Deployment, 0, len(list.Items))
 	for _, dp := range list.Items {
 		// check permissions
 		if !isManaged(&dp) {
@@ -323,7 +308,7 @@ func kubeRollbackHandler(c *gin.Context) {
 	// trigger events
 	triggerDeploymentEvent(deployment, "rollback")
 
-	var d *Deployment
+	var d *app.Deployment
 	dpNew, err := deploymentsClient.Get(deployment, metav1.GetOptions{})
 	if err != nil {
 		logger.Errorf("deployment get after rollback error: %v", err)
@@ -3,14 +3,17 @@ package controllers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gobwas/glob"
 	"github.com/gorilla/websocket"
 
 	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion/bus"
 	"github.com/tengattack/dandelion/cmd/dandelion/config"
 	"github.com/tengattack/tgo/logger"
 )
@@ -23,12 +26,24 @@ var wsUpgrader = websocket.Upgrader{
 	},
 }
 
-type wsConn struct {
-	appID      string
-	instanceID string
+// wsInstance is an app instance reported through websocket conn
+type wsInstance struct {
 	host       string
+	instanceID string
 	configID   int64
-	conn       *websocket.Conn
+}
+
+type wsConn struct {
+	appID string
+	// instances are all the reported instances of app, a seed may run
+	// several configs of an app
+	instances map[wsInstance]bool
+	conn      *websocket.Conn
+}
+
+// TableNameBusMessages the bus messages table
+func TableNameBusMessages() string {
+	return config.Conf.Database.TablePrefix + "dandelion_bus_messages"
 }
 
 var wsConnPool map[string][]*wsConn
@@ -38,33 +53,47 @@ func init() {
 	wsConnPool = make(map[string][]*wsConn)
 }
 
-func updateConnPoolInfo(conn *websocket.Conn, s *app.Status) {
-	wsConnPoolMutex.Lock()
-	defer wsConnPoolMutex.Unlock()
-	pool, ok := wsConnPool[s.AppID]
-	if !ok {
-		pool = make([]*wsConn, 0)
-	}
-
+// findConnPoolInfo finds or adds the pool info of conn for app, it should be
+// called with wsConnPoolMutex held
+func findConnPoolInfo(conn *websocket.Conn, appID string) *wsConn {
+	pool := wsConnPool[appID]
 	for _, c := range pool {
 		if c.conn == conn {
-			// found
-			c.host = s.Host
-			c.instanceID = s.InstanceID
-			c.configID = s.ConfigID
-			return
+			return c
 		}
 	}
+	c := &wsConn{
+		appID:     appID,
+		instances: make(map[wsInstance]bool),
+		conn:      conn,
+	}
+	wsConnPool[appID] = append(pool, c)
+	return c
+}
 
-	pool = append(pool, &wsConn{
-		appID:      s.AppID,
-		host:       s.Host,
-		instanceID: s.InstanceID,
-		configID:   s.ConfigID,
-		conn:       conn,
-	})
+// updateConnPoolInfo adds the instance of status to the pool info of conn
+func updateConnPoolInfo(conn *websocket.Conn, s *app.Status) {
+	wsConnPoolMutex.Lock()
+	defer wsConnPoolMutex.Unlock()
+	c := findConnPoolInfo(conn, s.AppID)
+	c.instances[wsInstance{host: s.Host, instanceID: s.InstanceID, configID: s.ConfigID}] = true
+}
 
-	wsConnPool[s.AppID] = pool
+// resetConnPoolInfo replaces the instances of conn by the statuses of ping,
+// which are all the last statuses of the apps reported through conn
+func resetConnPoolInfo(conn *websocket.Conn, statuses []app.Status) {
+	wsConnPoolMutex.Lock()
+	defer wsConnPoolMutex.Unlock()
+	instances := make(map[string]map[wsInstance]bool)
+	for _, s := range statuses {
+		if instances[s.AppID] == nil {
+			instances[s.AppID] = make(map[wsInstance]bool)
+		}
+		instances[s.AppID][wsInstance{host: s.Host, instanceID: s.InstanceID, configID: s.ConfigID}] = true
+	}
+	for appID, m := range instances {
+		findConnPoolInfo(conn, appID).instances = m
+	}
 }
 
 func removeConnPoolInfo(conn *websocket.Conn) {
@@ -98,8 +127,7 @@ func connWrite(c *wsConn, message []byte) {
 	if err != nil {
 		logger.WithFields(logger.Fields{
 			"app_id":      c.appID,
-			"host":        c.host,
-			"instance_id": c.instanceID,
+			"remote_addr": c.conn.RemoteAddr().String(),
 		}).Errorf("websocket conn write message error: %v", err)
 		// PASS
 	}
@@ -118,15 +146,89 @@ func notifyConn(m *app.NotifyMessage) {
 			// PASS
 		}
 	}
+	err = appendEvent(m, message)
+	if err != nil {
+		logger.Errorf("append event error: %v", err)
+		// PASS
+	}
+
+	if config.Bus != nil {
+		err = config.Bus.Publish(m)
+		if err != nil {
+			logger.Errorf("bus publish message error: %v", err)
+			// PASS
+		}
+	}
+
+	writeConns(m, message)
+}
+
+// notifyMatcher returns whether the conn should be notified of message.
+// Publish and rollback messages are notified to the conns matched the host
+// and instance id globs of config only, rollback messages are also notified
+// to the conns using the config, unless broadcast. A conn is notified if any
+// of its instances matches, or it has not reported any instance of the app yet.
+func notifyMatcher(m *app.NotifyMessage) func(c *wsConn) bool {
+	all := func(*wsConn) bool {
+		return true
+	}
+	if m.Broadcast || m.Config == nil || (m.Event != "publish" && m.Event != "rollback") {
+		return all
+	}
+	glob1, err1 := glob.Compile(m.Config.Host)
+	glob2, err2 := glob.Compile(m.Config.InstanceID)
+	if err1 != nil || err2 != nil {
+		logger.Warnf("config %d host or instance_id glob compile failed, broadcast", m.Config.ID)
+		return all
+	}
+	return func(c *wsConn) bool {
+		if len(c.instances) <= 0 {
+			return true
+		}
+		for i := range c.instances {
+			if m.Event == "rollback" && i.configID == m.Config.ID {
+				return true
+			}
+			if glob1.Match(i.host) && glob2.Match(i.instanceID) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// writeConns writes message to the websocket conns of app in this server
+// which are affected by the message
+func writeConns(m *app.NotifyMessage, message []byte) {
+	match := notifyMatcher(m)
 
 	wsConnPoolMutex.Lock()
 	defer wsConnPoolMutex.Unlock()
 
 	pool, ok := wsConnPool[m.AppID]
 	if ok {
+		n := 0
 		for _, c := range pool {
-			go connWrite(c, message)
+			if match(c) {
+				go connWrite(c, message)
+				n++
+			}
 		}
+		logger.Debugf("notified %d of %d conns of %s: %s", n, len(pool), m.AppID, m.Event)
+	}
+}
+
+// relayBusMessages relays the notify messages from other servers to the
+// websocket conns and long polls of this server
+func relayBusMessages(b bus.Bus) {
+	for m := range b.Messages() {
+		message, err := json.Marshal(m)
+		if err != nil {
+			logger.Errorf("encode message error: %v", err)
+			continue
+		}
+		wakeEventWaiters(m.AppID)
+		writeConns(m, message)
 	}
 }
 
@@ -137,7 +239,13 @@ func handleWebSocketMessage(conn *websocket.Conn, msg []byte) error {
 	if err != nil {
 		return err
 	}
+	return handleMessage(conn, &message)
+}
 
+// handleMessage handles the message from websocket conn, or from http if
+// conn is nil
+func handleMessage(conn *websocket.Conn, message *app.WSMessageRaw) error {
+	var err error
 	switch message.Action {
 	case "ping":
 		if message.Payload != nil {
@@ -146,12 +254,15 @@ func handleWebSocketMessage(conn *websocket.Conn, msg []byte) error {
 			if err != nil {
 				return err
 			}
+			if conn != nil {
+				resetConnPoolInfo(conn, payload)
+			}
 			for _, s := range payload {
+				s.DriftFiles = s.DriftFiles.Truncate(app.MaxDriftFilesLength)
 				s.UpdatedTime = time.Now().Unix()
-				updateConnPoolInfo(conn, &s)
 
 				_, err = config.DB.NamedExec("UPDATE "+TableNameInstances()+
-					" SET config_id = :config_id, commit_id = :commit_id, status = :status, updated_time = :updated_time"+
+					" SET config_id = :config_id, commit_id = :commit_id, status = :status, drift_files = :drift_files, updated_time = :updated_time"+
 					" WHERE app_id = :app_id AND host = :host AND instance_id = :instance_id", &s)
 				if err != nil {
 					logger.Errorf("update instance record failed: %v", err)
@@ -159,15 +270,31 @@ func handleWebSocketMessage(conn *websocket.Conn, msg []byte) error {
 				}
 			}
 		}
+		if conn == nil {
+			return nil
+		}
 		t := time.Now().Add(time.Second * 5)
 		return conn.WriteControl(websocket.PongMessage, nil, t)
+	case "inventory":
+		var payload app.Inventory
+		err = json.Unmarshal(message.Payload, &payload)
+		if err != nil {
+			return err
+		}
+		if payload.Host == "" {
+			return errors.New("empty inventory host")
+		}
+		return updateHostInventory(&payload)
 	case "status":
 		var payload app.Status
 		err = json.Unmarshal(message.Payload, &payload)
 		if err != nil {
 			return err
 		}
-		updateConnPoolInfo(conn, &payload)
+		if conn != nil {
+			updateConnPoolInfo(conn, &payload)
+		}
+		payload.DriftFiles = payload.DriftFiles.Truncate(app.MaxDriftFilesLength)
 
 		var row app.Status
 		err = config.DB.Get(&row, "SELECT id, config_id, commit_id, status FROM "+TableNameInstances()+
@@ -178,10 +305,11 @@ func handleWebSocketMessage(conn *websocket.Conn, msg []byte) error {
 			row.Host = payload.Host
 			row.InstanceID = payload.InstanceID
 			row.Status = payload.Status
+			row.DriftFiles = payload.DriftFiles
 			row.CreatedTime = time.Now().Unix()
 			row.UpdatedTime = row.CreatedTime
-			_, err = config.DB.NamedExec("INSERT INTO "+TableNameInstances()+" (app_id, host, instance_id, config_id, commit_id, status, created_time, updated_time)"+
-				" VALUES (:app_id, :host, :instance_id, :config_id, :commit_id, :status, :created_time, :updated_time)", &row)
+			_, err = config.DB.NamedExec("INSERT INTO "+TableNameInstances()+" (app_id, host, instance_id, config_id, commit_id, status, drift_files, created_time, updated_time)"+
+				" VALUES (:app_id, :host, :instance_id, :config_id, :commit_id, :status, :drift_files, :created_time, :updated_time)", &row)
 			if err != nil {
 				logger.Errorf("create new instance record failed: %v", err)
 				return err
@@ -191,18 +319,19 @@ func handleWebSocketMessage(conn *websocket.Conn, msg []byte) error {
 			return err
 		} else {
 			row.Status = payload.Status
+			row.DriftFiles = payload.DriftFiles
 			row.UpdatedTime = time.Now().Unix()
 			if row.ConfigID != payload.ConfigID || row.CommitID != payload.CommitID {
 				// update all
 				row.ConfigID = payload.ConfigID
 				row.CommitID = payload.CommitID
 				_, err = config.DB.NamedExec("UPDATE "+TableNameInstances()+
-					" SET config_id = :config_id, commit_id = :commit_id, status = :status, updated_time = :updated_time "+
+					" SET config_id = :config_id, commit_id = :commit_id, status = :status, drift_files = :drift_files, updated_time = :updated_time "+
 					" WHERE id = :id", &row)
 			} else {
 				// update status only
 				_, err = config.DB.NamedExec("UPDATE "+TableNameInstances()+
-					" SET status = :status, updated_time = :updated_time "+
+					" SET status = :status, drift_files = :drift_files, updated_time = :updated_time "+
 					" WHERE id = :id", &row)
 			}
 			if err != nil {
@@ -242,3 +371,22 @@ func wsPushHandler(c *gin.Context) {
 		}
 	}
 }
+
+// statusHandler reports the status, ping or inventory message over http,
+// for clients which can not upgrade to websocket
+func statusHandler(c *gin.Context) {
+	message := app.WSMessageRaw{
+		Action: c.PostForm("action"),
+	}
+	if payload := c.PostForm("payload"); payload != "" {
+		message.Payload = json.RawMessage(payload)
+	}
+
+	err := handleMessage(nil, &message)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	succeed(c, "success")
+}
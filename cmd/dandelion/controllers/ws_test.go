@@ -1,13 +1,20 @@
 package controllers
 
 import (
+	"encoding/json"
+	"net/http/httptest"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/cmd/dandelion/bus"
+	"github.com/tengattack/dandelion/cmd/dandelion/config"
 )
 
 func TestConnPool(t *testing.T) {
@@ -47,6 +54,25 @@ func TestConnPool(t *testing.T) {
 	removeConnPoolInfo(conn1)
 	_, ok := wsConnPool["s1"]
 	assert.False(ok, "conn pool for s1 should not exists")
+
+	// several configs of an app on a conn
+	updateConnPoolInfo(conn1, &app.Status{AppID: "s1", Host: "web-1", InstanceID: "a", ConfigID: 1})
+	updateConnPoolInfo(conn1, &app.Status{AppID: "s1", Host: "web-1", InstanceID: "b", ConfigID: 2})
+	updateConnPoolInfo(conn1, &app.Status{AppID: "s1", Host: "web-1", InstanceID: "a", ConfigID: 1})
+	require.Len(wsConnPool["s1"], 1)
+	assert.Equal(map[wsInstance]bool{
+		{host: "web-1", instanceID: "a", configID: 1}: true,
+		{host: "web-1", instanceID: "b", configID: 2}: true,
+	}, wsConnPool["s1"][0].instances)
+
+	// replaced by ping
+	resetConnPoolInfo(conn1, []app.Status{
+		{AppID: "s1", Host: "web-1", InstanceID: "a", ConfigID: 3},
+		{AppID: "s2", Host: "web-1", InstanceID: "a", ConfigID: 4},
+	})
+	assert.Equal(map[wsInstance]bool{{host: "web-1", instanceID: "a", configID: 3}: true}, wsConnPool["s1"][0].instances)
+	assert.Len(wsConnPool["s2"], 2)
+	removeConnPoolInfo(conn1)
 }
 
 func TestHandleWebSocketMessage(t *testing.T) {
@@ -75,6 +101,17 @@ func TestHandleWebSocketMessage(t *testing.T) {
 		[]byte(`{"action":"status","payload":{"app_id":"s1","host":"host1","instance_id":"instance1","config_id":2,"status":1}}`))
 	require.NoError(err)
 
+	err = handleWebSocketMessage(conn1,
+		[]byte(`{"action":"status","payload":{"app_id":"s1","host":"host1","instance_id":"instance1","config_id":2,"status":5,"drift_files":["a.conf","b/c.conf"]}}`))
+	require.NoError(err)
+
+	var row app.Status
+	err = config.DB.Get(&row, "SELECT * FROM "+TableNameInstances()+" WHERE app_id = ? AND host = ? AND instance_id = ?",
+		"s1", "host1", "instance1")
+	require.NoError(err)
+	assert.Equal(5, row.Status)
+	assert.Equal(app.FileList{"a.conf", "b/c.conf"}, row.DriftFiles)
+
 	// action ping
 	err = handleWebSocketMessage(conn1,
 		[]byte(`{"action":"ping"}`))
@@ -84,3 +121,139 @@ func TestHandleWebSocketMessage(t *testing.T) {
 		[]byte(`{"action":"ping","payload":[{"app_id":"s1","host":"host1","instance_id":"instance1","config_id":2,"status":1}]}`))
 	assert.EqualError(err, "websocket: write timeout")
 }
+
+func TestHandleInventoryMessage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conn1 := new(websocket.Conn)
+
+	err := handleWebSocketMessage(conn1,
+		[]byte(`{"action":"inventory","payload":{"host":""}}`))
+	assert.Error(err)
+
+	err = handleWebSocketMessage(conn1,
+		[]byte(`{"action":"inventory","payload":{"host":"host1","version":"dandelion-cli/0.3.0","os":"linux","arch":"amd64","uptime":10,"labels":{"zone":"sh","role":"web"},"apps":["s1"]}}`))
+	require.NoError(err)
+	err = handleWebSocketMessage(conn1,
+		[]byte(`{"action":"inventory","payload":{"host":"host2","version":"dandelion-cli/0.3.0","os":"linux","arch":"arm64","uptime":20,"labels":{"zone":"bj","role":"web"},"apps":["s1","s2"]}}`))
+	require.NoError(err)
+	// update
+	err = handleWebSocketMessage(conn1,
+		[]byte(`{"action":"inventory","payload":{"host":"host1","version":"dandelion-cli/0.3.1","os":"linux","arch":"amd64","uptime":30,"labels":{"zone":"sh","role":"web"},"apps":["s1"]}}`))
+	require.NoError(err)
+
+	sel, err := app.ParseSelector("role=web,zone!=bj")
+	require.NoError(err)
+	hosts, err := selectHosts(sel)
+	require.NoError(err)
+	require.Len(hosts, 1)
+	assert.Equal("host1", hosts[0].Host)
+	assert.Equal("dandelion-cli/0.3.1", hosts[0].Version)
+	assert.Equal(int64(30), hosts[0].Uptime)
+	assert.Equal(app.Labels{"zone": "sh", "role": "web"}, hosts[0].Labels)
+	assert.Equal(app.AppList{"s1"}, hosts[0].Apps)
+
+	hosts, err = selectHosts(nil)
+	require.NoError(err)
+	assert.Len(hosts, 2)
+}
+
+func TestRelayBusMessages(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/connect/push", wsPushHandler)
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	hub := bus.NewMemoryHub()
+	// the other server replica
+	other := hub.NewBus()
+	defer other.Close()
+	local := hub.NewBus()
+	defer local.Close()
+	go relayBusMessages(local)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/connect/push", nil)
+	require.NoError(err)
+	defer conn.Close()
+	require.NoError(conn.WriteMessage(websocket.TextMessage,
+		[]byte(`{"action":"status","payload":{"app_id":"r1","host":"host1","instance_id":"instance1","config_id":1,"status":3}}`)))
+	require.Eventually(func() bool {
+		wsConnPoolMutex.Lock()
+		defer wsConnPoolMutex.Unlock()
+		return len(wsConnPool["r1"]) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	waiter := eventWaiter("r1")
+	require.NoError(other.Publish(&app.NotifyMessage{AppID: "r1", Event: "publish", Config: &app.AppConfig{ID: 2, AppID: "r1", Host: "host*", InstanceID: "*"}}))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(err)
+	var m app.NotifyMessage
+	require.NoError(json.Unmarshal(msg, &m))
+	assert.Equal("publish", m.Event)
+	assert.Equal(int64(2), m.Config.ID)
+	select {
+	case <-waiter:
+	case <-time.After(5 * time.Second):
+		assert.Fail("long polls are not woken up")
+	}
+}
+
+func TestNotifyMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	newConn := func(instances ...wsInstance) *wsConn {
+		c := &wsConn{appID: "m1", instances: make(map[wsInstance]bool)}
+		for _, i := range instances {
+			c.instances[i] = true
+		}
+		return c
+	}
+	conns := []*wsConn{
+		newConn(wsInstance{host: "web-1", instanceID: "a", configID: 1}),
+		newConn(wsInstance{host: "web-2", instanceID: "b", configID: 2}),
+		newConn(wsInstance{host: "db-1", instanceID: "a", configID: 2}),
+	}
+	hostNames := []string{"web-1", "web-2", "db-1"}
+	matched := func(m *app.NotifyMessage) []string {
+		match := notifyMatcher(m)
+		var hosts []string
+		for i, c := range conns {
+			if match(c) {
+				hosts = append(hosts, hostNames[i])
+			}
+		}
+		return hosts
+	}
+
+	cfg := &app.AppConfig{ID: 3, AppID: "m1", Host: "web-*", InstanceID: "*"}
+	assert.Equal([]string{"web-1", "web-2"}, matched(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg}))
+	assert.Equal([]string{"web-1", "web-2", "db-1"}, matched(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg, Broadcast: true}))
+	assert.Equal([]string{"web-1", "web-2", "db-1"}, matched(&app.NotifyMessage{AppID: "m1", Event: "check"}))
+
+	cfg = &app.AppConfig{ID: 2, AppID: "m1", Host: "web-*", InstanceID: "a"}
+	assert.Equal([]string{"web-1"}, matched(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg}))
+	// the conns using the rolled back config are notified too
+	assert.Equal([]string{"web-1", "web-2", "db-1"}, matched(&app.NotifyMessage{AppID: "m1", Event: "rollback", Config: cfg}))
+
+	// invalid glob
+	cfg = &app.AppConfig{ID: 4, AppID: "m1", Host: "web-[", InstanceID: "*"}
+	assert.Len(matched(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg}), 3)
+
+	// two configs of an app on a conn
+	c := newConn(wsInstance{host: "web-3", instanceID: "a", configID: 5}, wsInstance{host: "web-3", instanceID: "b", configID: 6})
+	cfg = &app.AppConfig{ID: 7, AppID: "m1", Host: "*", InstanceID: "b"}
+	assert.True(notifyMatcher(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg})(c))
+	cfg = &app.AppConfig{ID: 5, AppID: "m1", Host: "*", InstanceID: "c"}
+	assert.False(notifyMatcher(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg})(c))
+	assert.True(notifyMatcher(&app.NotifyMessage{AppID: "m1", Event: "rollback", Config: cfg})(c))
+
+	// conns without reported instances are notified as fallback
+	assert.True(notifyMatcher(&app.NotifyMessage{AppID: "m1", Event: "publish", Config: cfg})(newConn()))
+}
@@ -9,7 +9,9 @@ import (
 	_ "go.uber.org/automaxprocs"
 
 	"github.com/tengattack/dandelion/client"
+	"github.com/tengattack/dandelion/cmd/dandelion/bus"
 	"github.com/tengattack/dandelion/cmd/dandelion/config"
+	"github.com/tengattack/dandelion/cmd/dandelion/controllers"
 	"github.com/tengattack/dandelion/log"
 	"github.com/tengattack/dandelion/mq"
 	"github.com/tengattack/dandelion/repository"
@@ -84,6 +86,21 @@ func main() {
 		config.MQ = m
 	}
 
+	switch config.Conf.Bus.Type {
+	case "":
+		// disabled
+	case "db":
+		b, err := bus.NewDBBus(db, controllers.TableNameBusMessages(), bus.NewReplicaID(), config.Conf.Bus.Interval)
+		if err != nil {
+			logger.Errorf("bus error: %v", err)
+			panic(err)
+		}
+		defer b.Close()
+		config.Bus = b
+	default:
+		panic(fmt.Errorf("unknown bus type %q", config.Conf.Bus.Type))
+	}
+
 	err = RunHTTPServer()
 	if err != nil {
 		logger.Errorf("http server error: %v", err)
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+)
+
+func runApps(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("apps", flag.ContinueOnError)
+	err := parseFlags(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	appIDs, err := Client.ListAppsContext(ctx)
+	if err != nil {
+		return err
+	}
+	return output(appIDs, func() error {
+		for _, appID := range appIDs {
+			fmt.Fprintln(stdout, appID)
+		}
+		return nil
+	})
+}
+
+func runCommits(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("commits", flag.ContinueOnError)
+	limit := fs.Int("n", 20, "limit the number of commits, 0 for all")
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	commits, err := Client.ListCommitsContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(commits) > *limit {
+		commits = commits[:*limit]
+	}
+	return output(commits, func() error {
+		rows := make([][]string, 0, len(commits))
+		for _, c := range commits {
+			rows = append(rows, []string{
+				shortID(c.CommitID), c.Branch, c.Author.Name,
+				c.Author.When.Local().Format("2006-01-02 15:04:05"), firstLine(c.Message),
+			})
+		}
+		return printTable([]string{"COMMIT", "BRANCH", "AUTHOR", "DATE", "MESSAGE"}, rows)
+	})
+}
+
+func runConfigs(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("configs", flag.ContinueOnError)
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	configs, err := Client.ListConfigsContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return output(configs, func() error {
+		rows := make([][]string, 0, len(configs))
+		for _, c := range configs {
+			rows = append(rows, []string{
+				strconv.FormatInt(c.ID, 10), c.Version, c.Host, c.InstanceID,
+				shortID(c.CommitID), c.Author, formatTime(c.CreatedTime),
+			})
+		}
+		return printTable([]string{"ID", "VERSION", "HOST", "INSTANCE", "COMMIT", "AUTHOR", "CREATED"}, rows)
+	})
+}
+
+func runInstances(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("instances", flag.ContinueOnError)
+	selector := fs.String("selector", "", "filter instances by host labels, e.g. zone=sh,role!=db")
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	instances, err := Client.ListInstancesContext(ctx, fs.Arg(0), *selector)
+	if err != nil {
+		return err
+	}
+	return output(instances, func() error {
+		rows := make([][]string, 0, len(instances))
+		for _, s := range instances {
+			drift := "-"
+			if len(s.DriftFiles) > 0 {
+				drift = strings.Join(s.DriftFiles, ",")
+			}
+			rows = append(rows, []string{
+				s.Host, s.InstanceID, client.InstanceStatus(s.Status).String(),
+				strconv.FormatInt(s.ConfigID, 10), shortID(s.CommitID), formatTime(s.UpdatedTime), drift,
+			})
+		}
+		return printTable([]string{"HOST", "INSTANCE", "STATUS", "CONFIG", "COMMIT", "UPDATED", "DRIFT"}, rows)
+	})
+}
+
+func runHosts(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("hosts", flag.ContinueOnError)
+	selector := fs.String("selector", "", "filter hosts by labels, e.g. zone=sh,role!=db")
+	err := parseFlags(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	hosts, err := Client.ListHostsContext(ctx, *selector)
+	if err != nil {
+		return err
+	}
+	return output(hosts, func() error {
+		rows := make([][]string, 0, len(hosts))
+		for _, h := range hosts {
+			rows = append(rows, []string{
+				h.Host, h.Version, h.OS + "/" + h.Arch, formatLabels(h.Labels),
+				strings.Join(h.Apps, ","), formatTime(h.UpdatedTime),
+			})
+		}
+		return printTable([]string{"HOST", "VERSION", "PLATFORM", "LABELS", "APPS", "UPDATED"}, rows)
+	})
+}
+
+func runSync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	err := parseFlags(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	r, err := Client.SyncContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return output(r, func() error {
+		fmt.Fprintf(stdout, "synced %d apps, head %s (%s)\n", len(r.AppIDs), r.Head.Name, shortID(r.Head.CommitID))
+		return nil
+	})
+}
+
+func runPublish(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	version := fs.String("version", "", "min version of instances (required)")
+	host := fs.String("host", "*", "glob of instance hosts")
+	instanceID := fs.String("instance", "*", "glob of instance ids")
+	broadcast := fs.Bool("broadcast", false, "notify all instances of app")
+	err := parseFlags(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if *version == "" {
+		return errUsage
+	}
+
+	cfg := &app.ClientConfig{
+		AppID:      fs.Arg(0),
+		Version:    *version,
+		Host:       *host,
+		InstanceID: *instanceID,
+	}
+	c, err := Client.PublishContext(ctx, cfg, fs.Arg(1), *broadcast)
+	if err != nil {
+		return err
+	}
+	return output(c, func() error {
+		fmt.Fprintf(stdout, "published config %d of %s: commit %s to host %s instance %s version %s\n",
+			c.ID, c.AppID, shortID(c.CommitID), c.Host, c.InstanceID, c.Version)
+		return nil
+	})
+}
+
+func runRollback(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	broadcast := fs.Bool("broadcast", false, "notify all instances of app")
+	err := parseFlags(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(fs.Arg(1), 10, 64)
+	if err != nil || id <= 0 {
+		return errUsage
+	}
+
+	c, err := Client.RollbackContext(ctx, fs.Arg(0), id, *broadcast)
+	if err != nil {
+		return err
+	}
+	return output(c, func() error {
+		fmt.Fprintf(stdout, "rolled back config %d of %s (commit %s)\n", c.ID, c.AppID, shortID(c.CommitID))
+		return nil
+	})
+}
+
+func runCheck(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	err = Client.CheckContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return output(map[string]string{"app_id": fs.Arg(0)}, func() error {
+		fmt.Fprintf(stdout, "notified instances of %s to check\n", fs.Arg(0))
+		return nil
+	})
+}
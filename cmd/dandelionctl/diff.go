@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/tengattack/dandelion/app"
+)
+
+// readArchive reads all files of archive
+func readArchive(z *zip.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(z.File))
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		fr, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(fr)
+		fr.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = data
+	}
+	return files, nil
+}
+
+// diffFiles returns the sorted differences between files
+func diffFiles(from, to map[string][]byte, nameOnly bool) ([]app.FileDiff, error) {
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []app.FileDiff{}
+	for _, name := range names {
+		a, inFrom := from[name]
+		b, inTo := to[name]
+		action := app.FileActionModify
+		if !inFrom {
+			action = app.FileActionAdd
+		} else if !inTo {
+			action = app.FileActionDelete
+		} else if bytes.Equal(a, b) {
+			continue
+		}
+		d := app.FileDiff{File: name, Action: action}
+		if !nameOnly {
+			var err error
+			d.Diff, err = app.UnifiedDiff(name, a, b, action)
+			if err != nil {
+				return nil, err
+			}
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	nameOnly := fs.Bool("name-only", false, "show names of changed files only")
+	err := parseFlags(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	appID := fs.Arg(0)
+	var files [2]map[string][]byte
+	for i, commitID := range fs.Args()[1:] {
+		z, err := Client.OpenZipArchiveContext(ctx, appID, commitID)
+		if err != nil {
+			return err
+		}
+		files[i], err = readArchive(z.Reader)
+		z.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	diffs, err := diffFiles(files[0], files[1], *nameOnly)
+	if err != nil {
+		return err
+	}
+	return output(diffs, func() error {
+		for _, d := range diffs {
+			if *nameOnly {
+				fmt.Fprintf(stdout, "%s\t%s\n", d.Action, d.File)
+				continue
+			}
+			fmt.Fprint(stdout, d.Diff)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+)
+
+// kube events
+const (
+	kubeEventComplete = "complete"
+	kubeEventTimeout  = "timeout"
+)
+
+// errors
+var (
+	errRolloutTimeout = errors.New("rollout timeout")
+	errEventsClosed   = errors.New("events connection closed")
+)
+
+func kubeCommands() []command {
+	return []command{
+		{"list", "", "list deployments", runKubeList},
+		{"detail", "<deployment>", "show deployment and its hpa", runKubeDetail},
+		{"tags", "<deployment>", "list image tags of deployment", runKubeTags},
+		{"set-tag", "[-wait] <deployment> <tag>", "set image tag of deployment", runKubeSetTag},
+		{"scale", "[-wait] <deployment> <replicas>", "set replicas of deployment", runKubeScale},
+		{"restart", "[-wait] <deployment>", "restart pods of deployment", runKubeRestart},
+		{"rollback", "[-wait] <deployment>", "roll back deployment to previous revision", runKubeRollback},
+		{"events", "[-wait] <deployment>", "tail rollout events of deployment", runKubeEvents},
+	}
+}
+
+func runKube(ctx context.Context, args []string) error {
+	return dispatch(ctx, "kube ", kubeCommands(), args)
+}
+
+func printDeployment(d *app.Deployment, h *app.HPA) error {
+	v := map[string]interface{}{"deployment": d}
+	if h != nil {
+		v["hpa"] = h
+	}
+	return output(v, func() error {
+		if d == nil {
+			return nil
+		}
+		fmt.Fprintf(stdout, "name:      %s\nimage:     %s\nreplicas:  %d\nrevision:  %d\n", d.Name, d.Image, d.Replicas, d.Revision)
+		if h != nil {
+			fmt.Fprintf(stdout, "hpa:       %s (min %d, max %d)\n", h.Name, h.MinReplicas, h.MaxReplicas)
+		}
+		return nil
+	})
+}
+
+func printKubeEvent(e *client.KubeEvent) error {
+	return output(e, func() error {
+		s := e.Status
+		if s == nil {
+			s = &client.KubeDeploymentStatus{}
+		}
+		fmt.Fprintf(stdout, "%s %s %s: replicas %d, updated %d, ready %d, available %d, unavailable %d\n",
+			e.Name, e.Action, e.Event, s.Replicas, s.UpdatedReplicas, s.ReadyReplicas, s.AvailableReplicas, s.UnavailableReplicas)
+		return nil
+	})
+}
+
+// tailKubeEvents prints rollout events until ctx done, or the rollout
+// finished if wait is true
+func tailKubeEvents(ctx context.Context, events <-chan client.KubeEvent, wait bool) error {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errEventsClosed
+			}
+			err := printKubeEvent(&e)
+			if err != nil {
+				return err
+			}
+			if wait {
+				switch e.Event {
+				case kubeEventComplete:
+					return nil
+				case kubeEventTimeout:
+					return errRolloutTimeout
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// kubeOperate runs the deployment operation, and then waits for the rollout
+// if wait is true, events are subscribed before the operation to not miss
+func kubeOperate(ctx context.Context, deployment string, wait bool, op func() (*app.Deployment, *app.HPA, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var events <-chan client.KubeEvent
+	if wait {
+		var err error
+		events, err = Client.KubeEvents(ctx, deployment)
+		if err != nil {
+			return err
+		}
+	}
+	d, h, err := op()
+	if err != nil {
+		return err
+	}
+	err = printDeployment(d, h)
+	if err != nil || !wait {
+		return err
+	}
+	return tailKubeEvents(ctx, events, true)
+}
+
+func runKubeList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube list", flag.ContinueOnError)
+	err := parseFlags(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	ds, err := Client.KubeListContext(ctx)
+	if err != nil {
+		return err
+	}
+	return output(ds, func() error {
+		rows := make([][]string, 0, len(ds))
+		for _, d := range ds {
+			rows = append(rows, []string{d.Name, d.Image, strconv.Itoa(d.Replicas), strconv.FormatInt(d.Revision, 10)})
+		}
+		return printTable([]string{"NAME", "IMAGE", "REPLICAS", "REVISION"}, rows)
+	})
+}
+
+func runKubeDetail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube detail", flag.ContinueOnError)
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	d, h, err := Client.KubeDetailContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printDeployment(d, h)
+}
+
+func runKubeTags(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube tags", flag.ContinueOnError)
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	tags, err := Client.KubeListTagsContext(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return output(tags, func() error {
+		for _, tag := range tags.Tags {
+			fmt.Fprintf(stdout, "%s:%s\n", tags.ImageName, tag)
+		}
+		return nil
+	})
+}
+
+func runKubeSetTag(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube set-tag", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "wait for the rollout")
+	err := parseFlags(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	return kubeOperate(ctx, fs.Arg(0), *wait, func() (*app.Deployment, *app.HPA, error) {
+		d, err := Client.KubeSetVersionTagContext(ctx, fs.Arg(0), fs.Arg(1))
+		return d, nil, err
+	})
+}
+
+func runKubeScale(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube scale", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "wait for the rollout")
+	err := parseFlags(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	replicas, err := strconv.Atoi(fs.Arg(1))
+	if err != nil || replicas < 0 {
+		return errUsage
+	}
+
+	return kubeOperate(ctx, fs.Arg(0), *wait, func() (*app.Deployment, *app.HPA, error) {
+		return Client.KubeSetReplicasContext(ctx, fs.Arg(0), replicas)
+	})
+}
+
+func runKubeRestart(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube restart", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "wait for the rollout")
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	return kubeOperate(ctx, fs.Arg(0), *wait, func() (*app.Deployment, *app.HPA, error) {
+		d, err := Client.KubeRestartContext(ctx, fs.Arg(0))
+		return d, nil, err
+	})
+}
+
+func runKubeRollback(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube rollback", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "wait for the rollout")
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	return kubeOperate(ctx, fs.Arg(0), *wait, func() (*app.Deployment, *app.HPA, error) {
+		d, err := Client.KubeRollbackContext(ctx, fs.Arg(0))
+		return d, nil, err
+	})
+}
+
+func runKubeEvents(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("kube events", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "exit after the rollout finished")
+	err := parseFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	events, err := Client.KubeEvents(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return tailKubeEvents(ctx, events, *wait)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tengattack/dandelion/client"
+)
+
+var (
+	// Client is the dandelion client instance
+	Client *client.DandelionClient
+	// Version control for dandelionctl
+	Version = "0.0.1-dev"
+
+	jsonOutput bool
+	stdout     io.Writer = os.Stdout
+	stderr     io.Writer = os.Stderr
+)
+
+// errors
+var (
+	errUsage = errors.New("invalid arguments")
+)
+
+// command is a dandelionctl sub command
+type command struct {
+	name string
+	args string
+	desc string
+	run  func(ctx context.Context, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"apps", "", "list apps", runApps},
+		{"commits", "<app_id>", "list commits of app", runCommits},
+		{"configs", "<app_id>", "list published configs of app", runConfigs},
+		{"instances", "[-selector <selector>] <app_id>", "list instances of app", runInstances},
+		{"hosts", "[-selector <selector>]", "list hosts", runHosts},
+		{"sync", "[app_id]", "sync config repository of app or all apps", runSync},
+		{"publish", "-version <version> [-host <glob>] [-instance <glob>] [-broadcast] <app_id> <commit_id>", "publish commit of app", runPublish},
+		{"rollback", "[-broadcast] <app_id> <config_id>", "roll back published config", runRollback},
+		{"check", "<app_id>", "notify instances of app to check configs", runCheck},
+		{"diff", "[-name-only] <app_id> <from_commit_id> <to_commit_id>", "diff files between commits", runDiff},
+		{"kube", "<command> [args]", "kube deployment operations, see `kube -help`", runKube},
+	}
+}
+
+func printCommands(w io.Writer, prefix string, cmds []command) {
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "  %s %s\n", prefix+cmd.name, cmd.args)
+		fmt.Fprintf(w, "    \t%s\n", cmd.desc)
+	}
+}
+
+// dispatch runs the command named by args[0] in cmds, the usage of command
+// is printed on errUsage
+func dispatch(ctx context.Context, prefix string, cmds []command, args []string) error {
+	if len(args) <= 0 || args[0] == "-help" || args[0] == "-h" {
+		fmt.Fprintln(stderr, "Commands:")
+		printCommands(stderr, prefix, cmds)
+		return errUsage
+	}
+	for _, cmd := range cmds {
+		if cmd.name == args[0] {
+			err := cmd.run(ctx, args[1:])
+			if err == errUsage && !strings.HasPrefix(cmd.args, "<command>") {
+				fmt.Fprintf(stderr, "Usage: dandelionctl %s%s %s\n", prefix, cmd.name, cmd.args)
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("unknown command %q", prefix+args[0])
+}
+
+// parseFlags parses flags of sub command, it returns errUsage if the count
+// of positional arguments is not in [min, max]
+func parseFlags(fs *flag.FlagSet, args []string, min, max int) error {
+	fs.SetOutput(stderr)
+	err := fs.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		return errUsage
+	}
+	return nil
+}
+
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dandelionctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	defaultServer := os.Getenv("DANDELION_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://127.0.0.1:9012"
+	}
+	server := fs.String("server", defaultServer, "dandelion server urls separated by comma (env: DANDELION_SERVER)")
+	timeout := fs.Duration("timeout", client.DefaultTimeout, "timeout of each request")
+	fs.BoolVar(&jsonOutput, "json", false, "output in json")
+	showVersion := fs.Bool("version", false, "show version")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: dandelionctl [flags] <command> [args]")
+		fmt.Fprintln(stderr, "\nFlags:")
+		fs.PrintDefaults()
+		fmt.Fprintln(stderr, "\nCommands:")
+		printCommands(stderr, "", commands())
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if *showVersion {
+		fmt.Fprintln(stdout, Version)
+		return nil
+	}
+	if fs.NArg() <= 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	urls := strings.Split(*server, ",")
+	client.SetVersion(Version)
+	Client, err = client.NewDandelionClient(urls[0], true,
+		client.WithEndpoints(urls[1:]...),
+		client.WithTimeout(*timeout))
+	if err != nil {
+		return err
+	}
+	defer Client.Close()
+
+	return dispatch(ctx, "", commands(), fs.Args())
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	err := run(ctx, os.Args[1:])
+	cancel()
+	if err == errUsage {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// formatTime formats unix timestamp in local time
+func formatTime(t int64) string {
+	if t <= 0 {
+		return "-"
+	}
+	return time.Unix(t, 0).Format("2006-01-02 15:04:05")
+}
+
+// shortID returns the short commit id
+func shortID(commitID string) string {
+	if len(commitID) > 10 {
+		return commitID[:10]
+	}
+	return commitID
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func runCtl(t *testing.T, args ...string) (string, error) {
+	var out bytes.Buffer
+	stdout, stderr = &out, &out
+	jsonOutput = false
+	err := run(context.Background(), args)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	archives := map[string][]byte{
+		"c1.zip": zipArchive(t, map[string]string{"a.conf": "x=1\ny=2\n", "b.conf": "b\n"}),
+		"c2.zip": zipArchive(t, map[string]string{"a.conf": "x=1\ny=3\n", "c.conf": "c\n"}),
+	}
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			assert.NoError(r.ParseForm())
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET " + client.APIPrefix + "/list":
+			w.Write([]byte(`{"code":0,"info":{"app_ids":["a","b"]}}`))
+		case "POST " + client.APIPrefix + "/publish/test":
+			assert.Equal("1.0", r.PostForm.Get("version"))
+			assert.Equal("*", r.PostForm.Get("host"))
+			assert.Equal("1", r.PostForm.Get("broadcast"))
+			w.Write([]byte(`{"code":0,"info":{"app_id":"test","config":{"id":3,"app_id":"test","version":"1.0","host":"*","instance_id":"*","commit_id":"` + r.PostForm.Get("commit_id") + `"}}}`))
+		case "GET " + client.APIPrefix + "/archive/test/c1.zip", "GET " + client.APIPrefix + "/archive/test/c2.zip":
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archives[r.URL.Path[len(client.APIPrefix+"/archive/test/"):]]))
+		case "POST " + client.APIPrefix + "/kube/setreplicas/web":
+			w.Write([]byte(`{"code":0,"info":{"deployment":{"name":"web","replicas":3},"hpa":null}}`))
+		case "GET /events/kube/web":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if !assert.NoError(err) {
+				return
+			}
+			defer conn.Close()
+			conn.WriteMessage(websocket.TextMessage, []byte("❤️"))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"web","action":"setreplicas","event":"processing","status":{"replicas":2}}`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"web","action":"setreplicas","event":"complete","status":{"replicas":3,"readyReplicas":3}}`))
+			conn.ReadMessage()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"info":"not found"}`))
+		}
+	}))
+	defer s.Close()
+
+	out, err := runCtl(t, "-server", s.URL, "apps")
+	assert.NoError(err)
+	assert.Equal("a\nb\n", out)
+
+	out, err = runCtl(t, "-server", s.URL, "-json", "apps")
+	assert.NoError(err)
+	var appIDs []string
+	assert.NoError(json.Unmarshal([]byte(out), &appIDs))
+	assert.Equal([]string{"a", "b"}, appIDs)
+
+	out, err = runCtl(t, "-server", s.URL, "publish", "test", "abcdef1234567")
+	assert.Equal(errUsage, err)
+	assert.Contains(out, "Usage: dandelionctl publish -version")
+
+	out, err = runCtl(t, "-server", s.URL, "publish", "-version", "1.0", "-broadcast", "test", "abcdef1234567")
+	assert.NoError(err)
+	assert.Equal("published config 3 of test: commit abcdef1234 to host * instance * version 1.0\n", out)
+
+	out, err = runCtl(t, "-server", s.URL, "rollback", "test", "3")
+	assert.Error(err)
+	assert.True(client.IsNotFound(err))
+
+	out, err = runCtl(t, "-server", s.URL, "diff", "-name-only", "test", "c1", "c2")
+	assert.NoError(err)
+	assert.Equal("modify\ta.conf\ndelete\tb.conf\nadd\tc.conf\n", out)
+
+	out, err = runCtl(t, "-server", s.URL, "diff", "test", "c1", "c2")
+	assert.NoError(err)
+	assert.Contains(out, "--- a/a.conf\n+++ b/a.conf\n")
+	assert.Contains(out, "-y=2\n+y=3\n")
+	assert.Contains(out, "--- a/b.conf\n+++ /dev/null\n")
+
+	out, err = runCtl(t, "-server", s.URL, "kube", "scale", "-wait", "web", "3")
+	assert.NoError(err)
+	assert.Contains(out, "replicas:  3\n")
+	assert.Contains(out, "web setreplicas processing: replicas 2")
+	assert.True(strings.HasSuffix(out, "web setreplicas complete: replicas 3, updated 0, ready 3, available 0, unavailable 0\n"))
+
+	out, err = runCtl(t, "-server", s.URL, "kube", "unknown")
+	assert.EqualError(err, `unknown command "kube unknown"`)
+}
+
+func TestDiffFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	from := map[string][]byte{"a": []byte("1\n"), "b": []byte("same\n"), "bin": {0, 1}}
+	to := map[string][]byte{"a": []byte("2\n"), "b": []byte("same\n"), "bin": {0, 2}}
+	diffs, err := diffFiles(from, to, false)
+	assert.NoError(err)
+	assert.Len(diffs, 2)
+	assert.Equal(app.FileActionModify, diffs[0].Action)
+	assert.Equal("--- a/a\n+++ b/a\n@@ -1 +1 @@\n-1\n+2\n", diffs[0].Diff)
+	assert.Equal("Binary files a/bin and b/bin differ\n", diffs[1].Diff)
+
+	// missing newline at end of file
+	diffs, err = diffFiles(map[string][]byte{"a": []byte("1")}, map[string][]byte{"a": []byte("2")}, false)
+	assert.NoError(err)
+	assert.Equal("--- a/a\n+++ b/a\n@@ -1 +1 @@\n-1\n\\ No newline at end of file\n+2\n\\ No newline at end of file\n", diffs[0].Diff)
+
+	diffs, err = diffFiles(nil, to, true)
+	assert.NoError(err)
+	assert.Len(diffs, 3)
+	for _, d := range diffs {
+		assert.Equal(app.FileActionAdd, d.Action)
+		assert.Empty(d.Diff)
+	}
+}
@@ -24,11 +24,27 @@ CREATE TABLE `dandelion_app_instances` (
   `instance_id` VARCHAR(50) NOT NULL DEFAULT '',
   `config_id` BIGINT(12) UNSIGNED NOT NULL DEFAULT '0',
   `commit_id` CHAR(40) NOT NULL DEFAULT '',
+  `drift_files` VARCHAR(4096) NOT NULL DEFAULT '' COMMENT 'drifted files, separated by newline',
   `created_time` BIGINT(12) UNSIGNED NOT NULL,
   `updated_time` BIGINT(12) UNSIGNED NOT NULL,
   KEY idx_appid_instanceid (`app_id`, `instance_id`)
 ) ENGINE=InnoDB CHARACTER SET=utf8 COLLATE=utf8_general_ci;
 
+DROP TABLE IF EXISTS `dandelion_hosts`;
+CREATE TABLE `dandelion_hosts` (
+  `id` BIGINT(12) UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `host` VARCHAR(128) NOT NULL DEFAULT '',
+  `version` VARCHAR(64) NOT NULL DEFAULT '' COMMENT 'seed version',
+  `os` VARCHAR(32) NOT NULL DEFAULT '',
+  `arch` VARCHAR(32) NOT NULL DEFAULT '',
+  `uptime` BIGINT(12) UNSIGNED NOT NULL DEFAULT '0' COMMENT 'seed uptime in seconds',
+  `labels` VARCHAR(4096) NOT NULL DEFAULT '' COMMENT 'labels in json',
+  `apps` VARCHAR(4096) NOT NULL DEFAULT '' COMMENT 'managed app ids, separated by newline',
+  `created_time` BIGINT(12) UNSIGNED NOT NULL,
+  `updated_time` BIGINT(12) UNSIGNED NOT NULL,
+  UNIQUE KEY uk_host (`host`)
+) ENGINE=InnoDB CHARACTER SET=utf8 COLLATE=utf8_general_ci;
+
 DROP TABLE IF EXISTS `dandelion_accesscheck`;
 CREATE TABLE `dandelion_accesscheck` (
   `id` BIGINT(12) UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
@@ -37,3 +53,23 @@ CREATE TABLE `dandelion_accesscheck` (
   `ip_cidr` VARCHAR(60) NOT NULL DEFAULT '',
   KEY idx_status (`status`)
 ) ENGINE=InnoDB CHARACTER SET=utf8 COLLATE=utf8_general_ci;
+
+DROP TABLE IF EXISTS `dandelion_app_events`;
+CREATE TABLE `dandelion_app_events` (
+  `id` BIGINT(12) UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `app_id` VARCHAR(32) NOT NULL DEFAULT '' COMMENT 'app id',
+  `event` VARCHAR(16) NOT NULL DEFAULT '' COMMENT 'publish, rollback or check',
+  `message` TEXT NOT NULL COMMENT 'notify message in json',
+  `created_time` BIGINT(12) UNSIGNED NOT NULL,
+  KEY idx_appid_id (`app_id`, `id`),
+  KEY idx_createdtime (`created_time`)
+) ENGINE=InnoDB CHARACTER SET=utf8 COLLATE=utf8_general_ci;
+
+DROP TABLE IF EXISTS `dandelion_bus_messages`;
+CREATE TABLE `dandelion_bus_messages` (
+  `id` BIGINT(12) UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `replica_id` VARCHAR(128) NOT NULL DEFAULT '' COMMENT 'server replica id',
+  `message` TEXT NOT NULL COMMENT 'notify message in json',
+  `created_time` BIGINT(12) UNSIGNED NOT NULL,
+  KEY idx_createdtime (`created_time`)
+) ENGINE=InnoDB CHARACTER SET=utf8 COLLATE=utf8_general_ci;
@@ -0,0 +1,466 @@
+// Package provider fetches the matched dandelion config of an app into
+// memory, for apps which can not run dandelion-seed beside them.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+)
+
+// errors
+var (
+	ErrFileNotFound      = errors.New("file not found")
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+	ErrNotLoaded         = errors.New("config not loaded")
+)
+
+const cacheConfigFile = "config.json"
+
+// ChangeFunc is called after config changed
+type ChangeFunc func(old, new *app.AppConfig)
+
+// ErrorFunc is called on errors of background refreshes
+type ErrorFunc func(err error)
+
+// Option configures Provider
+type Option func(p *Provider)
+
+// WithCacheDir keeps the loaded config in dir, the cached config is used if
+// dandelion server is unavailable on start
+func WithCacheDir(dir string) Option {
+	return func(p *Provider) {
+		p.cacheDir = dir
+	}
+}
+
+// WithClientOptions sets options of the dandelion client
+func WithClientOptions(opts ...client.Option) Option {
+	return func(p *Provider) {
+		p.clientOpts = append(p.clientOpts, opts...)
+	}
+}
+
+// WithErrorFunc sets the handler of background refresh errors
+func WithErrorFunc(f ErrorFunc) Option {
+	return func(p *Provider) {
+		p.errorFunc = f
+	}
+}
+
+// Provider provides the matched config of app in memory, it refreshes the
+// config when published or rolled back, and reports instance status like
+// dandelion-seed
+type Provider struct {
+	clientConfig app.ClientConfig
+	client       *client.DandelionClient
+	clientOpts   []client.Option
+	cacheDir     string
+	errorFunc    ErrorFunc
+
+	// refreshLock serializes refreshes
+	refreshLock sync.Mutex
+
+	mu        sync.RWMutex
+	config    *app.AppConfig
+	files     map[string][]byte
+	callbacks []ChangeFunc
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates the provider of app and loads its config. If cache dir is
+// set, the cached config is loaded on failure, and it keeps connecting to
+// dandelion server in background.
+func New(serverURL string, clientConfig app.ClientConfig, opts ...Option) (*Provider, error) {
+	p := &Provider{
+		clientConfig: clientConfig,
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := client.NewDandelionClient(serverURL, false, p.clientOpts...)
+	if err != nil {
+		if p.cacheDir == "" || p.loadCache() != nil {
+			cancel()
+			return nil, err
+		}
+		// serve the cached config until connected
+		p.client, _ = client.NewDandelionClient(serverURL, true, p.clientOpts...)
+		p.cancel = cancel
+		go p.run(ctx, serverURL, nil, false)
+		return p, nil
+	}
+
+	events, err := c.Watch(ctx, clientConfig.AppID)
+	if err != nil {
+		cancel()
+		c.Close()
+		return nil, err
+	}
+	p.client = c
+	err = p.Refresh(ctx)
+	if err != nil && (p.cacheDir == "" || p.loadCache() != nil) {
+		cancel()
+		c.Close()
+		return nil, err
+	}
+	p.cancel = cancel
+	go p.run(ctx, serverURL, events, err == nil)
+	return p, nil
+}
+
+func (p *Provider) handleError(err error) {
+	if p.errorFunc != nil {
+		p.errorFunc(err)
+	}
+}
+
+// setStatus reports the instance status to dandelion server, an error is
+// reported by the error func
+func (p *Provider) setStatus(ctx context.Context, dc *client.DandelionClient, status client.InstanceStatus, v ...interface{}) {
+	err := dc.SetStatusContext(ctx, &p.clientConfig, status, v...)
+	if err != nil && ctx.Err() == nil {
+		p.handleError(fmt.Errorf("set status error: %v", err))
+	}
+}
+
+func (p *Provider) getClient() *client.DandelionClient {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.client
+}
+
+// run connects to dandelion server with backoff if not connected, and then
+// refreshes config on events. Refreshes are retried with backoff until the
+// first success, the cached config is served meanwhile.
+func (p *Provider) run(ctx context.Context, serverURL string, events <-chan client.Event, refreshed bool) {
+	defer close(p.done)
+
+	wait := client.DefaultReconnectMinBackoff
+	for events == nil {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		wait *= 2
+		if wait > client.DefaultReconnectMaxBackoff {
+			wait = client.DefaultReconnectMaxBackoff
+		}
+
+		c, err := client.NewDandelionClient(serverURL, false, p.clientOpts...)
+		if err != nil {
+			p.handleError(err)
+			continue
+		}
+		events, err = c.Watch(ctx, p.clientConfig.AppID)
+		if err != nil {
+			c.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			// retry with backoff
+			p.handleError(err)
+			continue
+		}
+		p.mu.Lock()
+		old := p.client
+		p.client = c
+		p.mu.Unlock()
+		old.Close()
+
+		err = p.Refresh(ctx)
+		if err != nil {
+			p.handleError(err)
+		}
+		refreshed = err == nil
+	}
+
+	wait = client.DefaultReconnectMinBackoff
+	var retry <-chan time.Time
+	if !refreshed {
+		retry = time.After(wait)
+	}
+	disconnected := false
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			switch e.Type {
+			case client.EventDisconnected:
+				disconnected = true
+				continue
+			case client.EventConnected:
+				if !disconnected {
+					continue
+				}
+				// messages may be missed during disconnection
+				disconnected = false
+			}
+		case <-retry:
+		}
+		err := p.Refresh(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.handleError(err)
+		}
+		if refreshed {
+			continue
+		}
+		if err == nil {
+			refreshed = true
+			retry = nil
+			continue
+		}
+		wait *= 2
+		if wait > client.DefaultReconnectMaxBackoff {
+			wait = client.DefaultReconnectMaxBackoff
+		}
+		retry = time.After(wait)
+	}
+}
+
+// Close stops watching and closes the dandelion client
+func (p *Provider) Close() error {
+	p.cancel()
+	<-p.done
+	return p.getClient().Close()
+}
+
+// OnChange registers f to be called after config changed
+func (p *Provider) OnChange(f ChangeFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callbacks = append(p.callbacks, f)
+}
+
+// Config returns the loaded config
+func (p *Provider) Config() *app.AppConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config
+}
+
+// Files returns the sorted file names of loaded config
+func (p *Provider) Files() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	files := make([]string, 0, len(p.files))
+	for name := range p.files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// File returns the content of file in loaded config
+func (p *Provider) File(name string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.config == nil {
+		return nil, ErrNotLoaded
+	}
+	data, ok := p.files[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return nil, fmt.Errorf("%v: %s", ErrFileNotFound, name)
+	}
+	return data, nil
+}
+
+// Decode decodes json or yaml file to v by the file extension
+func (p *Provider) Decode(name string, v interface{}) error {
+	data, err := p.File(name)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return json.Unmarshal(data, v)
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(data, v)
+	}
+	return fmt.Errorf("%v: %s", ErrUnsupportedFormat, name)
+}
+
+// Refresh matches and loads the config from dandelion server, change
+// callbacks are called if the config changed
+func (p *Provider) Refresh(ctx context.Context) error {
+	p.refreshLock.Lock()
+	defer p.refreshLock.Unlock()
+
+	dc := p.getClient()
+	cfg := &p.clientConfig
+	p.setStatus(ctx, dc, client.StatusChecking)
+	c, err := dc.MatchContext(ctx, cfg)
+	if err != nil {
+		p.setStatus(ctx, dc, client.StatusError)
+		return err
+	}
+	v := map[string]interface{}{
+		"config_id": c.ID,
+		"commit_id": c.CommitID,
+	}
+
+	old := p.Config()
+	if old != nil && old.CommitID == c.CommitID {
+		p.mu.Lock()
+		p.config = c
+		p.mu.Unlock()
+		p.setStatus(ctx, dc, client.StatusSuccess, v)
+		return nil
+	}
+
+	p.setStatus(ctx, dc, client.StatusSyncing, v)
+	files, err := p.download(ctx, dc, c)
+	if err != nil {
+		p.setStatus(ctx, dc, client.StatusError, v)
+		return err
+	}
+	p.update(c, files)
+	p.setStatus(ctx, dc, client.StatusSuccess, v)
+
+	if p.cacheDir != "" {
+		err = p.saveCache(c, files)
+		if err != nil {
+			p.handleError(fmt.Errorf("save cache error: %v", err))
+		}
+	}
+	return nil
+}
+
+// download reads all files of config into memory
+func (p *Provider) download(ctx context.Context, dc *client.DandelionClient, c *app.AppConfig) (map[string][]byte, error) {
+	zr, err := dc.OpenZipArchiveContext(ctx, c.AppID, c.CommitID)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := make(map[string][]byte, len(zr.File))
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[zf.Name] = data
+	}
+	return files, nil
+}
+
+// update replaces the loaded config and calls change callbacks
+func (p *Provider) update(c *app.AppConfig, files map[string][]byte) {
+	p.mu.Lock()
+	old := p.config
+	p.config = c
+	p.files = files
+	callbacks := append([]ChangeFunc(nil), p.callbacks...)
+	p.mu.Unlock()
+
+	for _, f := range callbacks {
+		f(old, c)
+	}
+}
+
+func (p *Provider) appCacheDir() string {
+	return filepath.Join(p.cacheDir, p.clientConfig.AppID)
+}
+
+// saveCache replaces the cached config by the loaded config
+func (p *Provider) saveCache(c *app.AppConfig, files map[string][]byte) error {
+	dir := p.appCacheDir()
+	tmpDir := dir + ".tmp"
+	err := os.RemoveAll(tmpDir)
+	if err != nil {
+		return err
+	}
+	for name, data := range files {
+		filePath := filepath.Join(tmpDir, "files", filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filePath, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tmpDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(tmpDir, cacheConfigFile), data, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+// loadCache loads the cached config
+func (p *Provider) loadCache() error {
+	dir := p.appCacheDir()
+	data, err := ioutil.ReadFile(filepath.Join(dir, cacheConfigFile))
+	if err != nil {
+		return err
+	}
+	var c app.AppConfig
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return err
+	}
+	files := make(map[string][]byte)
+	filesDir := filepath.Join(dir, "files")
+	err = filepath.Walk(filesDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(filesDir, filePath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = ioutil.ReadFile(filePath)
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	p.update(&c, files)
+	return nil
+}
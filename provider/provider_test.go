@@ -0,0 +1,195 @@
+package provider
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tengattack/dandelion/app"
+	"github.com/tengattack/dandelion/client"
+)
+
+type fakeServer struct {
+	mu       sync.Mutex
+	commitID string
+	files    map[string]map[string]string
+	conns    chan *websocket.Conn
+	statuses chan map[string]interface{}
+	// failMatches is the number of match requests to fail
+	failMatches int
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	commitID := s.commitID
+	failMatch := s.failMatches > 0 && strings.HasPrefix(r.URL.Path, client.APIPrefix+"/match/")
+	if failMatch {
+		s.failMatches--
+	}
+	s.mu.Unlock()
+	switch {
+	case failMatch:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code":500,"info":"internal error"}`))
+	case r.URL.Path == "/connect/push":
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.conns <- conn
+		for {
+			var m struct {
+				Action  string                 `json:"action"`
+				Payload map[string]interface{} `json:"payload"`
+			}
+			err := conn.ReadJSON(&m)
+			if err != nil {
+				if _, ok := err.(*json.UnmarshalTypeError); ok {
+					continue
+				}
+				return
+			}
+			if m.Action == "status" {
+				s.statuses <- m.Payload
+			}
+		}
+	case strings.HasPrefix(r.URL.Path, client.APIPrefix+"/match/"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": 0,
+			"info": map[string]interface{}{
+				"app_id": "test",
+				"config": app.AppConfig{ID: 1, AppID: "test", CommitID: commitID},
+			},
+		})
+	case r.URL.Path == client.APIPrefix+"/archive/test/"+commitID+".zip":
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range s.files[commitID] {
+			f, _ := zw.Create(name)
+			f.Write([]byte(content))
+		}
+		zw.Close()
+		w.Write(buf.Bytes())
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":404,"info":"not found"}`))
+	}
+}
+
+func (s *fakeServer) nextStatus(t *testing.T, status client.InstanceStatus) map[string]interface{} {
+	for {
+		select {
+		case v := <-s.statuses:
+			if v["status"] == float64(status) {
+				return v
+			}
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "status timeout")
+		}
+	}
+}
+
+func TestProvider(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cacheDir, err := ioutil.TempDir("", "dandelion-provider")
+	require.NoError(err)
+	defer os.RemoveAll(cacheDir)
+
+	fs := &fakeServer{
+		commitID: "c1",
+		files: map[string]map[string]string{
+			"c1": {"app.json": `{"name":"a","port":80}`, "conf/db.yml": "host: db1\n"},
+			"c2": {"app.json": `{"name":"a","port":8080}`, "conf/db.yml": "host: db2\n"},
+		},
+		conns:    make(chan *websocket.Conn, 4),
+		statuses: make(chan map[string]interface{}, 64),
+	}
+	s := httptest.NewServer(fs)
+	defer s.Close()
+
+	cfg := app.ClientConfig{AppID: "test", Host: "h1", InstanceID: "i1", Version: "1"}
+	p, err := New(s.URL, cfg, WithCacheDir(cacheDir))
+	require.NoError(err)
+	conn := <-fs.conns
+	v := fs.nextStatus(t, client.StatusSuccess)
+	assert.Equal("c1", v["commit_id"])
+	assert.Equal("i1", v["instance_id"])
+
+	assert.Equal("c1", p.Config().CommitID)
+	assert.Equal([]string{"app.json", "conf/db.yml"}, p.Files())
+	var conf struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	}
+	require.NoError(p.Decode("app.json", &conf))
+	assert.Equal(80, conf.Port)
+	var db struct {
+		Host string `yaml:"host"`
+	}
+	require.NoError(p.Decode("conf/db.yml", &db))
+	assert.Equal("db1", db.Host)
+	_, err = p.File("missing.json")
+	assert.Error(err)
+
+	changed := make(chan [2]string, 1)
+	p.OnChange(func(old, new *app.AppConfig) {
+		changed <- [2]string{old.CommitID, new.CommitID}
+	})
+
+	// publish
+	fs.mu.Lock()
+	fs.commitID = "c2"
+	fs.mu.Unlock()
+	require.NoError(conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"publish","app_id":"test"}`)))
+	select {
+	case c := <-changed:
+		assert.Equal([2]string{"c1", "c2"}, c)
+	case <-time.After(5 * time.Second):
+		require.FailNow("change timeout")
+	}
+	require.NoError(p.Decode("conf/db.yml", &db))
+	assert.Equal("db2", db.Host)
+	fs.nextStatus(t, client.StatusSuccess)
+	require.NoError(p.Close())
+
+	// retries refresh after the cached config is loaded
+	fs.mu.Lock()
+	fs.commitID = "c1"
+	fs.failMatches = 1
+	fs.mu.Unlock()
+	p, err = New(s.URL, cfg, WithCacheDir(cacheDir))
+	require.NoError(err)
+	<-fs.conns
+	assert.Equal("c2", p.Config().CommitID)
+	v = fs.nextStatus(t, client.StatusSuccess)
+	assert.Equal("c1", v["commit_id"])
+	assert.Equal("c1", p.Config().CommitID)
+	require.NoError(p.Close())
+
+	// fallback to cache
+	s.Close()
+	p, err = New(s.URL, cfg, WithCacheDir(cacheDir))
+	require.NoError(err)
+	assert.Equal("c1", p.Config().CommitID)
+	require.NoError(p.Decode("app.json", &conf))
+	assert.Equal(80, conf.Port)
+	require.NoError(p.Close())
+
+	_, err = New(s.URL, cfg)
+	assert.Error(err)
+}
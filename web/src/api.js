@@ -4,7 +4,7 @@ export const PUBLIC_URL = window.PUBLIC_URL || '/'
 // PUBLIC_URL has last slash
 export const API_URL = PUBLIC_URL + 'api/v1'
 
-export const INSTANCE_STATUSES = [ 'offline', 'checking', 'syncing', 'online', 'error' ]
+export const INSTANCE_STATUSES = [ 'offline', 'checking', 'syncing', 'online', 'error', 'drifted' ]
 
 class ApiError extends ApiErrorA {
   constructor(status, statusText, response) {
@@ -117,7 +117,8 @@ export class AppCommit extends Component {
             return (
               <span key={i} className="instance" title={'belong to: ' + configStr}>
                 <span className={classNames('status', INSTANCE_STATUSES[instance.status])}
-                      title={INSTANCE_STATUSES[instance.status]}></span>
+                      title={INSTANCE_STATUSES[instance.status] +
+                        (instance.drift_files ? ': ' + instance.drift_files.join(', ') : '')}></span>
                 <span className="host">{ instance.host }</span>
                 <span className="instance-id">{ instance.instance_id }</span>
               </span>